@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ccampo133/log2fluent/internal"
+)
+
+// configValue is a value read from a config file: either a scalar, a list, or
+// a map. Scalars are kept as strings, since they are ultimately passed to
+// flag.Value.Set.
+type configValue struct {
+	line   int           // The line the value is on, or 0 if unknown.
+	scalar string        // The value, if it is a scalar.
+	list   []configValue // The elements, if it is a list.
+	fields []configField // The entries, in file order, if it is a map.
+	isList bool
+	isMap  bool
+}
+
+// configField is an entry of a map in a config file.
+type configField struct {
+	key   string
+	line  int // The line the key is on, or 0 if unknown.
+	value configValue
+}
+
+// configError is an error in a config file, with the line it is on.
+type configError struct {
+	path string
+	line int
+	err  error
+}
+
+func (e *configError) Error() string {
+	if e.line > 0 {
+		return fmt.Sprintf("%s:%d: %v", e.path, e.line, e.err)
+	}
+	return fmt.Sprintf("%s: %v", e.path, e.err)
+}
+
+func (e *configError) Unwrap() error {
+	return e.err
+}
+
+// Flags which can be repeated. In a config file, each element of a list given
+// for these is passed to the flag separately, rather than joined.
+var repeatableFlags = map[string]bool{"fd": true}
+
+// The separators between the keys and values of maps given for these flags,
+// if not "=".
+var mapSeparators = map[string]string{"parser-types": ":"}
+
+// Matches the line number and message of YAML parser errors.
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// loadConfig reads the config file at the given path. Its format is determined
+// by its extension: .yaml or .yml, .toml, or .json.
+func loadConfig(path string) (configValue, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return configValue{}, err
+	}
+	var root configValue
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		root, err = loadYAML(src)
+	case ".toml":
+		root, err = loadTOML(src)
+	case ".json":
+		root, err = loadJSON(src)
+	default:
+		return configValue{}, fmt.Errorf("unsupported config file extension %q", ext)
+	}
+	if err != nil {
+		var cerr *configError
+		if errors.As(err, &cerr) {
+			cerr.path = path
+			return configValue{}, cerr
+		}
+		return configValue{}, &configError{path: path, err: err}
+	}
+	if !root.isMap {
+		return configValue{}, &configError{path: path, line: root.line, err: errors.New("config must be a map")}
+	}
+	return root, nil
+}
+
+// loadYAML parses a YAML config file.
+func loadYAML(src []byte) (configValue, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(src, &doc); err != nil {
+		// The YAML parser only reports the line in the error message.
+		var (
+			line int
+			msg  string
+		)
+		if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ = strconv.Atoi(m[1])
+			msg = m[2]
+		}
+		if line > 0 {
+			return configValue{}, &configError{line: line, err: errors.New(msg)}
+		}
+		return configValue{}, err
+	}
+	if len(doc.Content) == 0 {
+		// An empty file.
+		return configValue{isMap: true}, nil
+	}
+	return yamlValue(doc.Content[0]), nil
+}
+
+func yamlValue(n *yaml.Node) configValue {
+	v := configValue{line: n.Line}
+	switch n.Kind {
+	case yaml.AliasNode:
+		return yamlValue(n.Alias)
+	case yaml.SequenceNode:
+		v.isList = true
+		for _, e := range n.Content {
+			v.list = append(v.list, yamlValue(e))
+		}
+	case yaml.MappingNode:
+		v.isMap = true
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := n.Content[i]
+			v.fields = append(v.fields, configField{key: k.Value, line: k.Line, value: yamlValue(n.Content[i+1])})
+		}
+	default:
+		if n.Tag != "!!null" {
+			v.scalar = n.Value
+		}
+	}
+	return v
+}
+
+// loadTOML parses a TOML config file. The TOML parser doesn't report where
+// keys are, so their lines are found by looking for them in the source.
+func loadTOML(src []byte) (configValue, error) {
+	var data map[string]any
+	if _, err := toml.Decode(string(src), &data); err != nil {
+		var perr toml.ParseError
+		if errors.As(err, &perr) {
+			return configValue{}, &configError{line: perr.Position.Line, err: errors.New(perr.Message)}
+		}
+		return configValue{}, err
+	}
+	lines := strings.Split(string(src), "\n")
+	return tomlValue(data, lines, nil, 0), nil
+}
+
+func tomlValue(data any, lines []string, table []string, line int) configValue {
+	v := configValue{line: line}
+	switch data := data.(type) {
+	case map[string]any:
+		v.isMap = true
+		for key, e := range data {
+			path := append(append([]string(nil), table...), key)
+			keyLine := tomlKeyLine(lines, table, key)
+			if keyLine == 0 {
+				keyLine = line
+			}
+			v.fields = append(v.fields, configField{key: key, line: keyLine, value: tomlValue(e, lines, path, keyLine)})
+		}
+		sort.SliceStable(v.fields, func(i, j int) bool { return v.fields[i].line < v.fields[j].line })
+	case []any:
+		v.isList = true
+		for _, e := range data {
+			v.list = append(v.list, tomlValue(e, lines, table, line))
+		}
+	case []map[string]any:
+		v.isList = true
+		for _, e := range data {
+			v.list = append(v.list, tomlValue(e, lines, table, line))
+		}
+	case float64:
+		v.scalar = strconv.FormatFloat(data, 'f', -1, 64)
+	default:
+		v.scalar = fmt.Sprint(data)
+	}
+	return v
+}
+
+// tomlKeyLine returns the line number of the key in the given table of a TOML
+// file, or 0 if it can't be found. The key is found either as a key = value
+// pair within the table, or as the header of a sub-table.
+func tomlKeyLine(lines []string, table []string, key string) int {
+	header := strings.Join(table, ".")
+	current := ""
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			name := strings.Trim(line, "[] ")
+			name = strings.ReplaceAll(name, `"`, "")
+			// Sub-tables may be declared without declaring their parent.
+			if full := strings.TrimPrefix(header+"."+key, "."); name == full || strings.HasPrefix(name, full+".") {
+				return i + 1
+			}
+			current = name
+			continue
+		}
+		if current != header {
+			continue
+		}
+		k, _, ok := strings.Cut(line, "=")
+		if ok && strings.Trim(strings.TrimSpace(k), `"'`) == key {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// loadJSON parses a JSON config file.
+func loadJSON(src []byte) (configValue, error) {
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.UseNumber()
+	v, err := jsonValue(dec, src)
+	if err != nil {
+		var serr *json.SyntaxError
+		if errors.As(err, &serr) {
+			return configValue{}, &configError{line: lineAt(src, int(serr.Offset)), err: err}
+		}
+		return configValue{}, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return configValue{}, &configError{line: lineAt(src, int(dec.InputOffset())), err: errors.New("trailing data")}
+	}
+	return v, nil
+}
+
+func jsonValue(dec *json.Decoder, src []byte) (configValue, error) {
+	line := lineAt(src, int(dec.InputOffset()))
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return configValue{}, err
+	}
+	v := configValue{line: line}
+	switch tok := tok.(type) {
+	case json.Delim:
+		switch tok {
+		case '{':
+			v.isMap = true
+			for dec.More() {
+				keyLine := lineAt(src, int(dec.InputOffset()))
+				key, err := dec.Token()
+				if err != nil {
+					return configValue{}, err
+				}
+				e, err := jsonValue(dec, src)
+				if err != nil {
+					return configValue{}, err
+				}
+				v.fields = append(v.fields, configField{key: key.(string), line: keyLine, value: e})
+			}
+		case '[':
+			v.isList = true
+			for dec.More() {
+				e, err := jsonValue(dec, src)
+				if err != nil {
+					return configValue{}, err
+				}
+				v.list = append(v.list, e)
+			}
+		}
+		// The closing delimiter.
+		if _, err := dec.Token(); err != nil {
+			return configValue{}, err
+		}
+	case nil:
+	default:
+		v.scalar = fmt.Sprint(tok)
+	}
+	return v, nil
+}
+
+// lineAt returns the line number of the first token at or after the given
+// offset of src.
+func lineAt(src []byte, offset int) int {
+	if offset > len(src) {
+		offset = len(src)
+	}
+	for offset < len(src) && strings.IndexByte(" \t\r\n,:", src[offset]) >= 0 {
+		offset++
+	}
+	return bytes.Count(src[:offset], []byte("\n")) + 1
+}
+
+// flagValues returns the values to pass to the flag with the given name's Set
+// method for the config value. Lists are joined by commas, unless the flag is
+// repeatable, and maps are joined into comma separated key=value pairs.
+func (v configValue) flagValues(name string) ([]string, error) {
+	switch {
+	case v.isList:
+		values := make([]string, len(v.list))
+		for i, e := range v.list {
+			if e.isList || e.isMap {
+				return nil, errors.New("expected a list of values")
+			}
+			values[i] = e.scalar
+		}
+		if repeatableFlags[name] {
+			return values, nil
+		}
+		return []string{strings.Join(values, ",")}, nil
+	case v.isMap:
+		sep := mapSeparators[name]
+		if sep == "" {
+			sep = "="
+		}
+		pairs := make([]string, len(v.fields))
+		for i, f := range v.fields {
+			if f.value.isList || f.value.isMap {
+				return nil, errors.New("expected a map of values")
+			}
+			pairs[i] = f.key + sep + f.value.scalar
+		}
+		return []string{strings.Join(pairs, ",")}, nil
+	default:
+		return []string{v.scalar}, nil
+	}
+}
+
+// setFlag sets the flag with the given name in the flag set to the config
+// value.
+func setFlag(fs *flag.FlagSet, name string, v configValue) error {
+	if fs.Lookup(name) == nil {
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	values, err := v.flagValues(name)
+	if err != nil {
+		return fmt.Errorf("invalid value for %q: %w", name, err)
+	}
+	for _, value := range values {
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value for %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// applyConfig applies the settings of the config file at the given path to
+// the flags of the flag set, except for those set explicitly on the command
+// line, which take precedence. The file's keys are the names of the flags,
+// except for "streams", which holds the settings of individual streams. Those
+// are returned as fdSpecs, whose settings start from the defaults - the flag
+// set's stream settings - and which don't override the settings set on the
+// command line either. Every error found in the file is returned.
+func applyConfig(path string, root configValue, fs *flag.FlagSet, defaults *streamSettings) (fdFlags, []error) {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	var (
+		errs    []error
+		streams *configField
+	)
+	for i, f := range root.fields {
+		switch {
+		case f.key == "streams":
+			streams = &root.fields[i]
+		case f.key == "config":
+			errs = append(errs, &configError{path, f.line, errors.New("config can't be set in a config file")})
+		case explicit[f.key]:
+		default:
+			if err := setFlag(fs, f.key, f.value); err != nil {
+				errs = append(errs, &configError{path, f.line, err})
+			}
+		}
+	}
+	if streams == nil {
+		return nil, errs
+	}
+	if !streams.value.isMap {
+		return nil, append(errs, &configError{path, streams.line, errors.New("streams must be a map")})
+	}
+	// Streams are configured once all the defaults have been applied, since
+	// they inherit them.
+	var specs fdFlags
+	fds := make(map[int]bool)
+	for _, f := range streams.value.fields {
+		spec, line, err := parseStream(f, *defaults, explicit)
+		if err == nil && fds[spec.fd] {
+			err = fmt.Errorf("fd %d is captured more than once", spec.fd)
+		}
+		if err != nil {
+			errs = append(errs, &configError{path, line, fmt.Errorf("stream %q: %w", f.key, err)})
+			continue
+		}
+		fds[spec.fd] = true
+		specs = append(specs, spec)
+	}
+	return specs, errs
+}
+
+// parseStream returns the spec of the stream configured in the given field of
+// the "streams" map. Its settings start from the given defaults, and those of
+// them set explicitly on the command line aren't overridden. If the
+// configuration is invalid, the line of the error is returned along with it.
+func parseStream(f configField, settings streamSettings, explicit map[string]bool) (fdSpec, int, error) {
+	if !f.value.isMap {
+		return fdSpec{}, f.line, errors.New("expected a map of settings")
+	}
+	if f.key == "" || strings.ContainsAny(f.key, `/\`) {
+		return fdSpec{}, f.line, errors.New("invalid stream name")
+	}
+	if slices.Contains(reservedStreams, f.key) {
+		return fdSpec{}, f.line, errors.New("stream name is reserved")
+	}
+	spec := fdSpec{stream: f.key, tee: &teeFlag{}, settings: &settings}
+	switch f.key {
+	case "stdout":
+		spec.fd = 1
+	case "stderr":
+		spec.fd = 2
+	}
+	fs := flag.NewFlagSet(f.key, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	settings.register(fs)
+	// The settings given on the command line, which the stream's don't
+	// override.
+	skip := make(map[string]bool)
+	fs.VisitAll(func(fl *flag.Flag) { skip[fl.Name] = explicit[fl.Name] })
+	if spec.fd != 0 {
+		skip["tee"] = explicit["tee-"+f.key]
+	}
+	if spec.fd == 0 {
+		// Only additional descriptors have a configurable fd.
+		fs.IntVar(&spec.fd, "fd", 0, "")
+	}
+	fs.StringVar(&spec.dest, "destinations", "", "")
+	fs.StringVar(&spec.tag, "tag", "", "")
+	fs.Var(spec.tee, "tee", "")
+	for _, sf := range f.value.fields {
+		if skip[sf.key] {
+			continue
+		}
+		if err := setFlag(fs, sf.key, sf.value); err != nil {
+			return fdSpec{}, sf.line, err
+		}
+	}
+	if spec.fd == 0 {
+		return fdSpec{}, f.line, errors.New("missing fd")
+	}
+	if spec.fd < 3 && f.key != "stdout" && f.key != "stderr" {
+		return fdSpec{}, f.line, errors.New("fd must be greater than 2")
+	}
+	if _, err := settings.options(internal.ForwarderOptions{}); err != nil {
+		return fdSpec{}, f.line, err
+	}
+	return spec, f.line, nil
+}
+
+// merge returns the specs with those of the streams configured in a config
+// file merged in. A configured stream with the same descriptor and stream name
+// as one of the specs fills in what the spec doesn't set, and the others are
+// added. The result should be validated, since a configured stream may reuse
+// the descriptor or stream name of a different spec.
+func (f fdFlags) merge(streams fdFlags) fdFlags {
+	for _, c := range streams {
+		i := slices.IndexFunc(f, func(spec fdSpec) bool { return spec.fd == c.fd && spec.stream == c.stream })
+		if i < 0 {
+			f = append(f, c)
+			continue
+		}
+		spec := &f[i]
+		if spec.dest == "" {
+			spec.dest = c.dest
+		}
+		if spec.tag == "" {
+			spec.tag = c.tag
+		}
+		if spec.tee == nil {
+			spec.tee = c.tee
+		}
+		if spec.settings == nil {
+			spec.settings = c.settings
+		}
+	}
+	return f
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ccampo133/log2fluent/internal"
+	"github.com/stretchr/testify/require"
+)
+
+// testFlags returns a flag set with some of log2fluent's flags and the stream
+// settings flags, and the stream settings they set.
+func testFlags(t *testing.T, args ...string) (*flag.FlagSet, *string, *uint, *streamSettings) {
+	t.Helper()
+	var (
+		tag       string
+		batchSize uint
+		extraFds  fdFlags
+		defaults  = newStreamSettings()
+	)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	defaults.register(fs)
+	fs.StringVar(&tag, "tag", "", "")
+	fs.UintVar(&batchSize, "batch-size", 1, "")
+	fs.Var(&extraFds, "fd", "")
+	require.NoError(t, fs.Parse(args))
+	return fs, &tag, &batchSize, &defaults
+}
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func Test_applyConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "yaml",
+			file: "config.yaml",
+			content: `tag: myapp
+batch-size: 10
+parser-types:
+  status: int
+streams:
+  stderr:
+    destinations: [localhost:24224, localhost:24225]
+    mode: broadcast
+    format: logfmt
+  audit:
+    fd: 3
+    destinations: localhost:24226
+    tag: audit
+    multiline-timeout: 2s
+`,
+		},
+		{
+			name: "json",
+			file: "config.json",
+			content: `{
+  "tag": "myapp",
+  "batch-size": 10,
+  "parser-types": {"status": "int"},
+  "streams": {
+    "stderr": {
+      "destinations": ["localhost:24224", "localhost:24225"],
+      "mode": "broadcast",
+      "format": "logfmt"
+    },
+    "audit": {
+      "fd": 3,
+      "destinations": "localhost:24226",
+      "tag": "audit",
+      "multiline-timeout": "2s"
+    }
+  }
+}`,
+		},
+		{
+			name: "toml",
+			file: "config.toml",
+			content: `tag = "myapp"
+batch-size = 10
+parser-types = { status = "int" }
+
+[streams.stderr]
+destinations = ["localhost:24224", "localhost:24225"]
+mode = "broadcast"
+format = "logfmt"
+
+[streams.audit]
+fd = 3
+destinations = "localhost:24226"
+tag = "audit"
+multiline-timeout = "2s"
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				path := writeConfig(t, tt.file, tt.content)
+				root, err := loadConfig(path)
+				require.NoError(t, err)
+				fs, tag, batchSize, defaults := testFlags(t)
+				streams, errs := applyConfig(path, root, fs, defaults)
+				require.Empty(t, errs)
+				require.Equal(t, "myapp", *tag)
+				require.EqualValues(t, 10, *batchSize)
+				require.Equal(t, "status:int", defaults.parserTypes)
+				require.Len(t, streams, 2)
+
+				stderr := streams[0]
+				require.Equal(t, 2, stderr.fd)
+				require.Equal(t, "stderr", stderr.stream)
+				require.Equal(t, "localhost:24224,localhost:24225", stderr.dest)
+				require.Equal(t, internal.ModeBroadcast, stderr.settings.mode)
+				require.Equal(t, internal.FormatLogfmt, stderr.settings.format)
+				// Stream settings start from the defaults.
+				require.Equal(t, "status:int", stderr.settings.parserTypes)
+
+				audit := streams[1]
+				require.Equal(t, 3, audit.fd)
+				require.Equal(t, "audit", audit.stream)
+				require.Equal(t, "audit", audit.tag)
+				require.Equal(t, 2*time.Second, audit.settings.multilineTimeout)
+				require.Equal(t, internal.FormatPlain, audit.settings.format)
+				// The defaults aren't changed by stream settings.
+				require.Equal(t, internal.FormatPlain, defaults.format)
+			},
+		)
+	}
+}
+
+func Test_applyConfig_FlagsTakePrecedence(t *testing.T) {
+	path := writeConfig(t, "config.yaml", "tag: fromfile\nbatch-size: 10\n")
+	root, err := loadConfig(path)
+	require.NoError(t, err)
+	fs, tag, batchSize, defaults := testFlags(t, "-tag", "fromflag")
+	_, errs := applyConfig(path, root, fs, defaults)
+	require.Empty(t, errs)
+	require.Equal(t, "fromflag", *tag)
+	require.EqualValues(t, 10, *batchSize)
+}
+
+func Test_applyConfig_FlagsTakePrecedenceOverStreams(t *testing.T) {
+	path := writeConfig(
+		t,
+		"config.yaml",
+		"streams:\n  stdout:\n    format: json\n    multiline-timeout: 2s\n",
+	)
+	root, err := loadConfig(path)
+	require.NoError(t, err)
+	fs, _, _, defaults := testFlags(t, "-format", "logfmt")
+	streams, errs := applyConfig(path, root, fs, defaults)
+	require.Empty(t, errs)
+	require.Len(t, streams, 1)
+	require.Equal(t, internal.FormatLogfmt, streams[0].settings.format)
+	require.Equal(t, 2*time.Second, streams[0].settings.multilineTimeout)
+}
+
+func Test_applyConfig_RepeatableFlag(t *testing.T) {
+	path := writeConfig(t, "config.yaml", "fd:\n  - 3=localhost:24224\n  - 4=localhost:24225\n")
+	root, err := loadConfig(path)
+	require.NoError(t, err)
+	fs, _, _, defaults := testFlags(t)
+	_, errs := applyConfig(path, root, fs, defaults)
+	require.Empty(t, errs)
+	extraFds := *fs.Lookup("fd").Value.(*fdFlags)
+	require.Len(t, extraFds, 2)
+	require.Equal(t, 3, extraFds[0].fd)
+	require.Equal(t, 4, extraFds[1].fd)
+}
+
+func Test_applyConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []string
+	}{
+		{
+			name: "yaml",
+			file: "config.yaml",
+			content: `tag: myapp
+unknown: 1
+batch-size: lots
+streams:
+  audit:
+    destinations: localhost:24224
+  stdout:
+    format: xml
+`,
+			want: []string{
+				`config.yaml:2: unknown setting "unknown"`,
+				`config.yaml:3: invalid value for "batch-size"`,
+				`config.yaml:5: stream "audit": missing fd`,
+				`config.yaml:8: stream "stdout": invalid value for "format"`,
+			},
+		},
+		{
+			name: "json",
+			file: "config.json",
+			content: `{
+  "tag": "myapp",
+  "unknown": 1,
+  "streams": {
+    "audit": {
+      "fd": 1
+    },
+    "lifecycle": {
+      "fd": 3
+    }
+  }
+}`,
+			want: []string{
+				`config.json:3: unknown setting "unknown"`,
+				`config.json:5: stream "audit": fd must be greater than 2`,
+				`config.json:8: stream "lifecycle": stream name is reserved`,
+			},
+		},
+		{
+			name: "toml",
+			file: "config.toml",
+			content: `tag = "myapp"
+
+unknown = 1
+
+[streams.stdout]
+multiline-start = "^\\d"
+multiline-preset = "java"
+`,
+			want: []string{
+				`config.toml:3: unknown setting "unknown"`,
+				`config.toml:5: stream "stdout": -multiline-start can't be combined with -multiline-preset`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				path := writeConfig(t, tt.file, tt.content)
+				root, err := loadConfig(path)
+				require.NoError(t, err)
+				fs, _, _, defaults := testFlags(t)
+				_, errs := applyConfig(path, root, fs, defaults)
+				require.Len(t, errs, len(tt.want))
+				for i, err := range errs {
+					require.ErrorContains(t, err, tt.want[i])
+				}
+			},
+		)
+	}
+}
+
+func Test_loadConfig_SyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{
+			name:    "yaml",
+			file:    "config.yaml",
+			content: "tag: myapp\nstreams:\n  - a\n b: c\n",
+			want:    "config.yaml:3: ",
+		},
+		{
+			name:    "json",
+			file:    "config.json",
+			content: "{\n  \"tag\": \"myapp\",\n  \"batch-size\" 10\n}",
+			want:    "config.json:3: ",
+		},
+		{
+			name:    "toml",
+			file:    "config.toml",
+			content: "tag = \"myapp\"\nbatch-size = \n",
+			want:    "config.toml:2: ",
+		},
+		{
+			name:    "unsupported extension",
+			file:    "config.ini",
+			content: "tag=myapp",
+			want:    "unsupported config file extension",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				_, err := loadConfig(writeConfig(t, tt.file, tt.content))
+				require.ErrorContains(t, err, tt.want)
+			},
+		)
+	}
+}
+
+func Test_fdFlags_merge(t *testing.T) {
+	settings := newStreamSettings()
+	tee := &teeFlag{enabled: true}
+	specs := fdFlags{
+		{fd: 1, dest: "localhost:24224", stream: "stdout"},
+		{fd: 3, dest: "localhost:24225", stream: "audit", tag: "fromflag"},
+		{fd: 4, dest: "localhost:24226", stream: "access"},
+	}
+	streams := fdFlags{
+		{fd: 1, dest: "localhost:1", stream: "stdout", tee: tee, settings: &settings},
+		{fd: 3, dest: "localhost:2", stream: "audit", tag: "fromfile"},
+		{fd: 5, dest: "localhost:3", stream: "metrics"},
+	}
+	want := fdFlags{
+		{fd: 1, dest: "localhost:24224", stream: "stdout", tee: tee, settings: &settings},
+		{fd: 3, dest: "localhost:24225", stream: "audit", tag: "fromflag"},
+		{fd: 4, dest: "localhost:24226", stream: "access"},
+		{fd: 5, dest: "localhost:3", stream: "metrics"},
+	}
+	merged := specs.merge(streams)
+	require.Equal(t, want, merged)
+	require.NoError(t, merged.validate())
+}
+
+func Test_fdFlags_merge_Conflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		streams fdFlags
+	}{
+		{
+			name:    "same fd, different stream",
+			streams: fdFlags{{fd: 3, dest: "localhost:1", stream: "access"}},
+		},
+		{
+			name:    "same stream, different fd",
+			streams: fdFlags{{fd: 4, dest: "localhost:1", stream: "audit"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				specs := fdFlags{{fd: 3, dest: "localhost:24224", stream: "audit"}}
+				merged := specs.merge(tt.streams)
+				require.Len(t, merged, 2)
+				require.Error(t, merged.validate())
+			},
+		)
+	}
+}
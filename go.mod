@@ -3,8 +3,12 @@ module github.com/ccampo133/log2fluent
 go 1.22
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/IBM/fluent-forward-go v0.2.2
 	github.com/stretchr/testify v1.9.0
+	github.com/tinylib/msgp v1.2.0
+	golang.org/x/sys v0.23.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -16,8 +20,5 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rogpeppe/go-internal v1.12.0 // indirect
 	github.com/stretchr/objx v0.5.2 // indirect
-	github.com/tinylib/msgp v1.2.0 // indirect
 	golang.org/x/net v0.28.0 // indirect
-	golang.org/x/sys v0.23.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
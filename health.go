@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ccampo133/log2fluent/internal"
+)
+
+// healthHandler returns an http.Handler for /healthz, which reports whether
+// the child process is running.
+func healthHandler(running *atomic.Bool) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			if !running.Load() {
+				http.Error(w, "child process is not running", http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = fmt.Fprintln(w, "ok")
+		},
+	)
+}
+
+// readyHandler returns an http.Handler for /readyz, which reports whether
+// every Forwarder is connected to Fluent, and has its message buffer no more
+// than the given fraction full.
+func readyHandler(fwdrs []*internal.Forwarder, maxBuffer float64) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			if problems := unready(fwdrs, maxBuffer); len(problems) > 0 {
+				http.Error(w, strings.Join(problems, "\n"), http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = fmt.Fprintln(w, "ok")
+		},
+	)
+}
+
+// unready returns why each of the Forwarders which isn't ready isn't.
+func unready(fwdrs []*internal.Forwarder, maxBuffer float64) []string {
+	var problems []string
+	for _, fwdr := range fwdrs {
+		if !fwdr.Connected() {
+			problems = append(problems, fmt.Sprintf("%s: not connected to Fluent", fwdr.Name()))
+		}
+		stats := fwdr.Stats()
+		if stats.QueueCap > 0 && float64(stats.QueueLen) > maxBuffer*float64(stats.QueueCap) {
+			problems = append(
+				problems,
+				fmt.Sprintf("%s: message buffer is %d/%d full", fwdr.Name(), stats.QueueLen, stats.QueueCap),
+			)
+		}
+	}
+	return problems
+}
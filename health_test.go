@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ccampo133/log2fluent/internal"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestForwarder returns a Forwarder of the given lines to a mock Logger
+// which is connected if connected is true, and whose Log blocks until release
+// is closed. The Forwarder blocks when its buffer is full, and is waited for
+// when the test ends.
+func newTestForwarder(t *testing.T, name, lines string, bufLen uint, connected bool, release <-chan struct{}) *internal.Forwarder {
+	logger := internal.NewMockLogger(t)
+	logger.On("Connect").Return(nil).Maybe()
+	logger.On("IsConnected").Return(connected)
+	logger.On("Log", mock.Anything).Run(func(mock.Arguments) { <-release }).Return(nil).Maybe()
+	logger.On("Disconnect").Return(nil)
+	fwdr := internal.NewForwarder(
+		name, io.NopCloser(strings.NewReader(lines)), logger, internal.ForwarderOptions{BufLen: bufLen, Backpressure: internal.BackpressureBlock},
+	)
+	fwdr.Forward()
+	t.Cleanup(
+		func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			require.NoError(t, fwdr.Wait(ctx))
+		},
+	)
+	return fwdr
+}
+
+func Test_healthHandler(t *testing.T) {
+	var running atomic.Bool
+	handler := healthHandler(&running)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	running.Store(true)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func Test_readyHandler(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	connected := newTestForwarder(t, "stdout", "", 8, true, release)
+	disconnected := newTestForwarder(t, "stderr", "", 8, false, release)
+
+	rec := httptest.NewRecorder()
+	readyHandler([]*internal.Forwarder{connected}, 0.9).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	readyHandler([]*internal.Forwarder{connected, disconnected}, 0.9).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "stderr: not connected to Fluent\n", rec.Body.String())
+}
+
+func Test_unready_BufferFull(t *testing.T) {
+	release := make(chan struct{})
+	// The first line is held by the Logger, and the rest fill the buffer.
+	fwdr := newTestForwarder(t, "stdout", "1\n2\n3\n4\n5\n6\n", 4, true, release)
+	defer close(release)
+	require.Eventually(
+		t,
+		func() bool { return fwdr.Stats().QueueLen == 4 },
+		10*time.Second,
+		10*time.Millisecond,
+	)
+	require.Equal(t, []string{"stdout: message buffer is 4/4 full"}, unready([]*internal.Forwarder{fwdr}, 0.9))
+	require.Empty(t, unready([]*internal.Forwarder{fwdr}, 1))
+}
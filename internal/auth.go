@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/IBM/fluent-forward-go/fluent/client"
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// The maximum time allowed for the authentication handshake to complete.
+const handshakeTimeout = 10 * time.Second
+
+// AuthOptions holds the credentials used to authenticate with a fluent server
+// which requires the Forward protocol's handshake, e.g. Fluentd with a
+// <security> section.
+type AuthOptions struct {
+	// SharedKey is the key shared between the client and the server.
+	SharedKey string
+	// SelfHostname is the client's hostname, which is sent to the server and
+	// used to compute the shared key digest.
+	SelfHostname string
+	// Username and Password are used if the server requires user
+	// authentication.
+	Username, Password string
+}
+
+// authConnFactory is a client.ConnectionFactory which performs the Forward
+// protocol handshake on each new connection before handing it out.
+type authConnFactory struct {
+	client.ConnectionFactory
+	opts AuthOptions
+}
+
+func (f *authConnFactory) New() (net.Conn, error) {
+	conn, err := f.ConnectionFactory.New()
+	if err != nil {
+		return nil, err
+	}
+	if err := handshake(conn, f.opts); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("error during handshake: %w", err)
+	}
+	return conn, nil
+}
+
+// handshake performs the client side of the Forward protocol handshake on the
+// given connection: it waits for the server's HELO, responds with a PING
+// containing the shared key digest (and credentials, if requested), and then
+// validates the server's PONG.
+func handshake(conn net.Conn, opts AuthOptions) error {
+	if err := conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return err
+	}
+	r := msgp.NewReader(conn)
+	var helo protocol.Helo
+	if err := helo.DecodeMsg(r); err != nil {
+		return fmt.Errorf("error reading HELO: %w", err)
+	}
+	if helo.MessageType != protocol.MsgTypeHelo || helo.Options == nil {
+		return fmt.Errorf("expected HELO but got %q", helo.MessageType)
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	var password string
+	if len(helo.Options.Auth) > 0 {
+		// The server requires user authentication, in which case the password
+		// is sent as a salted digest rather than in the clear.
+		password = passwordDigest(helo.Options.Auth, opts.Username, opts.Password)
+	}
+	ping, err := protocol.NewPingWithAuth(
+		opts.SelfHostname,
+		[]byte(opts.SharedKey),
+		salt,
+		helo.Options.Nonce,
+		opts.Username,
+		password,
+	)
+	if err != nil {
+		return err
+	}
+	if err := msgp.Encode(conn, ping); err != nil {
+		return fmt.Errorf("error sending PING: %w", err)
+	}
+	var pong protocol.Pong
+	if err := pong.DecodeMsg(r); err != nil {
+		return fmt.Errorf("error reading PONG: %w", err)
+	}
+	if pong.MessageType != protocol.MsgTypePong {
+		return fmt.Errorf("expected PONG but got %q", pong.MessageType)
+	}
+	if !pong.AuthResult {
+		return fmt.Errorf("authentication failed: %s", pong.Reason)
+	}
+	err = protocol.ValidatePongDigest(&pong, []byte(opts.SharedKey), helo.Options.Nonce, salt)
+	if err != nil {
+		return fmt.Errorf("invalid PONG digest: %w", err)
+	}
+	// Clear the deadline for the rest of the connection's lifetime.
+	return conn.SetDeadline(time.Time{})
+}
+
+// passwordDigest returns the password digest as specified by the Forward
+// protocol, i.e. hex(sha512(auth_salt + username + password)).
+func passwordDigest(authSalt []byte, username, password string) string {
+	h := sha512.New()
+	h.Write(authSalt)
+	h.Write([]byte(username))
+	h.Write([]byte(password))
+	return hex.EncodeToString(h.Sum(nil))
+}
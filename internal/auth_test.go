@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"net"
+	"testing"
+
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// authServer is a fake fluent server which performs the server side of the
+// Forward protocol handshake.
+type authServer struct {
+	sharedKey string
+	// If username is set, user authentication is required.
+	username, password string
+	// If pongKey is set, it is used to compute the PONG digest instead of
+	// sharedKey, simulating a server which doesn't know the shared key.
+	pongKey string
+}
+
+// start starts the server and returns its address.
+func (s *authServer) start(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_ = s.handshake(conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func (s *authServer) handshake(conn net.Conn) error {
+	opts := &protocol.HeloOpts{Nonce: []byte("nonce"), Keepalive: true}
+	if s.username != "" {
+		opts.Auth = []byte("auth-salt")
+	}
+	helo := protocol.NewHelo(opts)
+	if err := msgp.Encode(conn, helo); err != nil {
+		return err
+	}
+	var ping protocol.Ping
+	if err := ping.DecodeMsg(msgp.NewReader(conn)); err != nil {
+		return err
+	}
+	ok, reason := true, ""
+	if err := protocol.ValidatePingDigest(&ping, []byte(s.sharedKey), opts.Nonce); err != nil {
+		ok, reason = false, "shared key mismatch"
+	} else if s.username != "" &&
+		(ping.Username != s.username || ping.Password != passwordDigest(opts.Auth, s.username, s.password)) {
+		ok, reason = false, "username/password mismatch"
+	}
+	key := s.sharedKey
+	if s.pongKey != "" {
+		key = s.pongKey
+	}
+	pong, err := protocol.NewPong(ok, reason, "server", []byte(key), helo, &ping)
+	if err != nil {
+		return err
+	}
+	if err := msgp.Encode(conn, pong); err != nil {
+		return err
+	}
+	// Keep the connection open until the client is done with it.
+	_, _ = conn.Read(make([]byte, 1))
+	return nil
+}
+
+func TestFluentLogger_Connect_Auth(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  *authServer
+		auth    AuthOptions
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "shared key",
+			server:  &authServer{sharedKey: "secret"},
+			auth:    AuthOptions{SharedKey: "secret", SelfHostname: "client"},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "wrong shared key",
+			server:  &authServer{sharedKey: "secret"},
+			auth:    AuthOptions{SharedKey: "wrong", SelfHostname: "client"},
+			wantErr: require.Error,
+		},
+		{
+			name:   "shared key and user",
+			server: &authServer{sharedKey: "secret", username: "user", password: "pass"},
+			auth: AuthOptions{
+				SharedKey:    "secret",
+				SelfHostname: "client",
+				Username:     "user",
+				Password:     "pass",
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:   "wrong password",
+			server: &authServer{sharedKey: "secret", username: "user", password: "pass"},
+			auth: AuthOptions{
+				SharedKey:    "secret",
+				SelfHostname: "client",
+				Username:     "user",
+				Password:     "wrong",
+			},
+			wantErr: require.Error,
+		},
+		{
+			name:    "server doesn't know the shared key",
+			server:  &authServer{sharedKey: "secret", pongKey: "other"},
+			auth:    AuthOptions{SharedKey: "secret", SelfHostname: "client"},
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				addr := tt.server.start(t)
+				logger := NewFluentLogger(
+					"tcp", addr, "tag", "stdout", nil, FluentLoggerOptions{Auth: &tt.auth},
+				)
+				tt.wantErr(t, logger.Connect())
+				_ = logger.Disconnect()
+			},
+		)
+	}
+}
@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The special time formats supported by TimeParser, in addition to strftime
+// layouts.
+const (
+	// TimeFormatRFC3339 parses RFC 3339 timestamps, with or without fractional
+	// seconds, e.g. 2006-01-02T15:04:05.999Z. This is the default.
+	TimeFormatRFC3339 = "rfc3339"
+	// TimeFormatEpoch parses (possibly fractional) seconds since the Unix
+	// epoch, given either as a number or a string, e.g. 1136214245.999.
+	TimeFormatEpoch = "epoch"
+)
+
+// strftimeDirectives maps the supported strftime directives to the equivalent
+// Go time layout elements.
+var strftimeDirectives = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "02",
+	'e': "_2",
+	'H': "15",
+	'I': "03",
+	'M': "04",
+	'S': "05",
+	'L': "000",
+	'f': "000000",
+	'N': "000000000",
+	'p': "PM",
+	'b': "Jan",
+	'h': "Jan",
+	'B': "January",
+	'a': "Mon",
+	'A': "Monday",
+	'j': "002",
+	'z': "-0700",
+	'Z': "MST",
+	'F': "2006-01-02",
+	'T': "15:04:05",
+	'%': "%",
+}
+
+// TimeParser extracts the event time of a message from one of its parsed
+// fields.
+type TimeParser struct {
+	key    string // The field holding the time.
+	layout string // The Go time layout, if not epoch.
+	epoch  bool   // Whether the time is in seconds since the epoch.
+}
+
+// NewTimeParser returns a TimeParser which reads the time from the field with
+// the given key, in the given format: TimeFormatRFC3339, TimeFormatEpoch, or a
+// strftime layout, e.g. "%Y-%m-%d %H:%M:%S". Times without a zone are taken to
+// be in UTC. An error is returned if the format is invalid.
+func NewTimeParser(key, format string) (*TimeParser, error) {
+	if key == "" {
+		return nil, errors.New("time key must not be empty")
+	}
+	switch format {
+	case "", TimeFormatRFC3339:
+		return &TimeParser{key: key, layout: time.RFC3339Nano}, nil
+	case TimeFormatEpoch:
+		return &TimeParser{key: key, epoch: true}, nil
+	}
+	if !strings.Contains(format, "%") {
+		return nil, fmt.Errorf("invalid time format %q", format)
+	}
+	layout, err := strftimeLayout(format)
+	if err != nil {
+		return nil, err
+	}
+	return &TimeParser{key: key, layout: layout}, nil
+}
+
+// Time returns the time held by the parser's key in the fields. The boolean is
+// false if there is no such field, or if its value can't be parsed.
+func (p *TimeParser) Time(fields map[string]any) (time.Time, bool) {
+	v, ok := fields[p.key]
+	if !ok {
+		return time.Time{}, false
+	}
+	var (
+		t   time.Time
+		err error
+	)
+	switch v := v.(type) {
+	case string:
+		if p.epoch {
+			t, err = parseEpoch(v)
+		} else {
+			t, err = time.Parse(p.layout, v)
+		}
+	case int64:
+		if !p.epoch {
+			return time.Time{}, false
+		}
+		t = time.Unix(v, 0)
+	case float64:
+		if !p.epoch {
+			return time.Time{}, false
+		}
+		t, err = parseEpoch(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return time.Time{}, false
+	}
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// parseEpoch parses seconds since the epoch, with an optional fractional part
+// of up to nanosecond precision.
+func parseEpoch(s string) (time.Time, error) {
+	secStr, fracStr, _ := strings.Cut(s, ".")
+	sec, err := strconv.ParseInt(secStr, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nsec int64
+	if fracStr != "" {
+		if len(fracStr) > 9 {
+			fracStr = fracStr[:9]
+		}
+		if nsec, err = strconv.ParseInt(fracStr, 10, 64); err != nil || nsec < 0 {
+			return time.Time{}, fmt.Errorf("invalid epoch time %q", s)
+		}
+		for i := len(fracStr); i < 9; i++ {
+			nsec *= 10
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+// literalCheckTime is formatted with the literal text of strftime layouts, to
+// check that it doesn't contain any Go time layout elements: each element
+// formats it differently from how the element is written.
+var literalCheckTime = time.Date(1999, time.December, 31, 10, 59, 58, 123456789, time.FixedZone("XYZ", 3*60*60))
+
+// strftimeLayout converts a strftime layout into a Go time layout. An error is
+// returned if the layout's literal text (i.e. outside of directives) contains
+// Go time layout elements, e.g. "Jan" or "1", since they would be read as part
+// of the time rather than matched literally.
+func strftimeLayout(format string) (string, error) {
+	var b strings.Builder
+	literalStart := 0
+	checkLiteral := func(end int) error {
+		literal := format[literalStart:end]
+		if literalCheckTime.Format(literal) != literal {
+			return fmt.Errorf("invalid time format %q: literal text %q contains time layout elements", format, literal)
+		}
+		return nil
+	}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		if err := checkLiteral(i); err != nil {
+			return "", err
+		}
+		i++
+		if i == len(format) {
+			return "", fmt.Errorf("invalid time format %q: trailing %%", format)
+		}
+		if format[i] == ':' && i+1 < len(format) && format[i+1] == 'z' {
+			// %:z is the zone offset with a colon, e.g. -07:00.
+			b.WriteString("-07:00")
+			i++
+			literalStart = i + 1
+			continue
+		}
+		elem, ok := strftimeDirectives[format[i]]
+		if !ok {
+			return "", fmt.Errorf("invalid time format %q: unsupported directive %%%c", format, format[i])
+		}
+		b.WriteString(elem)
+		literalStart = i + 1
+	}
+	if err := checkLiteral(len(format)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeParser_Time(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		value  any
+		want   time.Time
+		wantOk bool
+	}{
+		{
+			name:   "rfc3339 is the default",
+			value:  "2024-03-01T12:30:45Z",
+			want:   time.Date(2024, 3, 1, 12, 30, 45, 0, time.UTC),
+			wantOk: true,
+		},
+		{
+			name:   "rfc3339 with nanoseconds and offset",
+			format: TimeFormatRFC3339,
+			value:  "2024-03-01T12:30:45.123456789+02:00",
+			want:   time.Date(2024, 3, 1, 10, 30, 45, 123456789, time.UTC),
+			wantOk: true,
+		},
+		{
+			name:   "epoch int",
+			format: TimeFormatEpoch,
+			value:  int64(1709296245),
+			want:   time.Date(2024, 3, 1, 12, 30, 45, 0, time.UTC),
+			wantOk: true,
+		},
+		{
+			name:   "epoch float",
+			format: TimeFormatEpoch,
+			value:  1709296245.5,
+			want:   time.Date(2024, 3, 1, 12, 30, 45, 500000000, time.UTC),
+			wantOk: true,
+		},
+		{
+			name:   "epoch string with nanoseconds",
+			format: TimeFormatEpoch,
+			value:  "1709296245.000000001",
+			want:   time.Date(2024, 3, 1, 12, 30, 45, 1, time.UTC),
+			wantOk: true,
+		},
+		{
+			name:   "strftime",
+			format: "%d/%b/%Y:%H:%M:%S %z",
+			value:  "01/Mar/2024:12:30:45 +0000",
+			want:   time.Date(2024, 3, 1, 12, 30, 45, 0, time.UTC),
+			wantOk: true,
+		},
+		{
+			name:   "strftime with milliseconds and no zone",
+			format: "%Y-%m-%d %H:%M:%S.%L",
+			value:  "2024-03-01 12:30:45.250",
+			want:   time.Date(2024, 3, 1, 12, 30, 45, 250000000, time.UTC),
+			wantOk: true,
+		},
+		{
+			name:   "strftime with literal text",
+			format: "at %Y-%m-%dT%H:%M:%S%:z (UTC)",
+			value:  "at 2024-03-01T14:30:45+02:00 (UTC)",
+			want:   time.Date(2024, 3, 1, 12, 30, 45, 0, time.UTC),
+			wantOk: true,
+		},
+		{
+			name:  "invalid value",
+			value: "yesterday",
+		},
+		{
+			name:  "number for layout",
+			value: int64(1709296245),
+		},
+		{
+			name:   "invalid epoch",
+			format: TimeFormatEpoch,
+			value:  "12:30",
+		},
+		{
+			name:   "unsupported type",
+			format: TimeFormatEpoch,
+			value:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				p, err := NewTimeParser("time", tt.format)
+				require.NoError(t, err)
+				got, ok := p.Time(map[string]any{"time": tt.value})
+				require.Equal(t, tt.wantOk, ok)
+				require.True(t, tt.want.Equal(got), "want %v, got %v", tt.want, got)
+			},
+		)
+	}
+}
+
+func TestTimeParser_Time_MissingKey(t *testing.T) {
+	p, err := NewTimeParser("time", "")
+	require.NoError(t, err)
+	_, ok := p.Time(map[string]any{"ts": "2024-03-01T12:30:45Z"})
+	require.False(t, ok)
+}
+
+func TestNewTimeParser_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		format string
+	}{
+		{name: "empty key", format: TimeFormatRFC3339},
+		{name: "unknown format", key: "time", format: "iso8601"},
+		{name: "unsupported directive", key: "time", format: "%Y-%Q"},
+		{name: "trailing percent", key: "time", format: "%Y%"},
+		{name: "literal number", key: "time", format: "v1 %Y-%m-%d"},
+		{name: "literal month", key: "time", format: "%d Jan %Y"},
+		{name: "literal weekday", key: "time", format: "Mon %Y-%m-%d"},
+		{name: "literal zone", key: "time", format: "%H:%M MST"},
+		{name: "literal fraction", key: "time", format: "%H:%M:%S.0"},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				_, err := NewTimeParser(tt.key, tt.format)
+				require.Error(t, err)
+			},
+		)
+	}
+}
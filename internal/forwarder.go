@@ -2,19 +2,137 @@ package internal
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"strings"
+	"maps"
+	"time"
 )
 
+// errConnect is wrapped by the errors returned when a Forwarder's Logger can't
+// connect.
+var errConnect = errors.New("error connecting logger")
+
+// How often a Forwarder retries connecting its Logger, and sending spilled
+// messages, when it has nothing else to do. It is a variable for testing.
+var retryInterval = 5 * time.Second
+
+// The maximum number of spilled messages replayed before their removal from the
+// spill is committed, unless a batch size is set.
+const spillReplayLen = 100
+
+// Backpressure is the strategy a Forwarder uses when its message buffer is
+// full.
+type Backpressure string
+
+const (
+	// BackpressureDrop drops the newest message when the buffer is full. This is
+	// the default.
+	BackpressureDrop Backpressure = "drop"
+	// BackpressureBlock stops reading from the source until there is room in
+	// the buffer, which in turn blocks the writer of the source (e.g. the child
+	// process writing to a pipe).
+	BackpressureBlock Backpressure = "block"
+	// BackpressureDropOldest evicts the oldest buffered message to make room for
+	// the newest one when the buffer is full.
+	BackpressureDropOldest Backpressure = "drop-oldest"
+)
+
+// MarshalText implements encoding.TextMarshaler.
+func (b Backpressure) MarshalText() ([]byte, error) {
+	return []byte(b), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It returns an error if the
+// text is not a valid Backpressure strategy.
+func (b *Backpressure) UnmarshalText(text []byte) error {
+	switch bp := Backpressure(text); bp {
+	case BackpressureDrop, BackpressureBlock, BackpressureDropOldest:
+		*b = bp
+		return nil
+	default:
+		return fmt.Errorf("invalid backpressure strategy %q", text)
+	}
+}
+
 // Forwarder forwards messages from some source reader (typically a read-only
 // fd from an os.Pipe) to some destination fluentWriter.
 type Forwarder struct {
-	name   string        // The forwarder's name, e.g. "stdout" or "stderr".
-	bufLen uint          // The message channel buffer length.
-	src    io.ReadCloser // Where we read the logs from.
-	logger Logger        // Where we send the logs to.
+	name          string         // The forwarder's name, e.g. "stdout" or "stderr".
+	bufLen        uint           // The message channel buffer length.
+	backpressure  Backpressure   // What to do when the message buffer is full.
+	spill         *Spill         // Where unsendable messages go, if not nil.
+	parser        Parser         // Parses lines into fields, if not nil.
+	keepRaw       bool           // Whether to keep the raw line of parsed lines.
+	multiline     *Multiline     // Aggregates multiline records, if not nil.
+	timeParser    *TimeParser    // Parses event times from fields, if not nil.
+	maxLineBytes  uint           // Max line length, 0 for unlimited.
+	longLines     LongLinePolicy // What to do with lines over maxLineBytes.
+	partialFlush  time.Duration  // Idle time before emitting a partial line.
+	tee           *Tee           // Where to echo what is read, if not nil.
+	batchSize     uint           // Max number of messages per batch.
+	batchBytes    uint           // Max number of message bytes per batch.
+	flushInterval time.Duration  // Max time a batch is held before sending.
+	src           io.ReadCloser  // Where we read the logs from.
+	logger        Logger         // Where we send the logs to.
+	msgs          chan Message   // The message buffer, once forwarding.
+	stats         stats          // Counters of what the Forwarder did.
+	done          chan struct{}  // Closed once the writer goroutine exits.
+}
+
+// ForwarderOptions holds the optional settings of a Forwarder.
+type ForwarderOptions struct {
+	// BufLen is the message channel buffer length.
+	BufLen uint
+	// Backpressure is what to do when the message buffer is full. Defaults to
+	// BackpressureDrop.
+	Backpressure Backpressure
+	// Spill, if not nil, is where messages that couldn't be sent are stored
+	// until they can be replayed. Otherwise, such messages are dropped.
+	Spill *Spill
+	// Parser, if not nil, parses each line into structured fields. Lines which
+	// can't be parsed are sent as plain lines.
+	Parser Parser
+	// KeepRaw, if true, adds the raw line to the fields of parsed lines, under
+	// the "log" key.
+	KeepRaw bool
+	// Multiline, if not nil, aggregates consecutive lines (e.g. stack traces)
+	// into single messages before they are parsed.
+	Multiline *Multiline
+	// TimeParser, if not nil, extracts each parsed message's event time from
+	// its fields. Otherwise, or if the time can't be extracted, messages are
+	// timestamped with the time they were read.
+	TimeParser *TimeParser
+	// MaxLineBytes is the maximum length of a line, in bytes. 0 means lines may
+	// be arbitrarily long.
+	MaxLineBytes uint
+	// LongLines is what to do with lines longer than MaxLineBytes. Defaults to
+	// LongLineTruncate.
+	LongLines LongLinePolicy
+	// PartialFlush, if greater than 0, is how long the Forwarder waits for the
+	// rest of a line (i.e. a newline) before sending what it has so far as a
+	// partial record, annotated with partial=true. The rest of the line is sent
+	// as a separate record. 0 means partial lines are held until they are
+	// complete.
+	PartialFlush time.Duration
+	// Tee, if not nil, is where everything read from the source is echoed to,
+	// e.g. log2fluent's own stdout. The Tee is closed once the source has been
+	// fully read.
+	Tee *Tee
+	// BatchSize is the maximum number of messages sent to the Logger in a
+	// single batch. Batching is enabled if it is greater than 1, or if
+	// BatchBytes is set.
+	BatchSize uint
+	// BatchBytes is the maximum number of message bytes sent to the Logger in a
+	// single batch. A batch is sent once it reaches this size, or BatchSize
+	// messages, whichever comes first. 0 means no limit.
+	BatchBytes uint
+	// FlushInterval is the maximum time a batch is held before being sent,
+	// regardless of its size. 0 means batches are only sent once full.
+	FlushInterval time.Duration
 }
 
 // NewForwarder returns a new Forwarder based on an input stream and a fluent
@@ -22,11 +140,48 @@ type Forwarder struct {
 // error is returned and Forwarder.writer is set to nil. Then the connection
 // will be retried on each successive call for Forwarder.Forward, and
 // Forwarder.writer will be set once the connection is successful.
-func NewForwarder(name string, bufLen uint, src io.ReadCloser, logger Logger) *Forwarder {
+func NewForwarder(name string, src io.ReadCloser, logger Logger, opts ForwarderOptions) *Forwarder {
 	if err := logger.Connect(); err != nil {
 		slog.Debug("error connecting logger; will be retried on first message", "name", name, "error", err)
 	}
-	return &Forwarder{name: name, bufLen: bufLen, src: src, logger: logger}
+	return &Forwarder{
+		name:          name,
+		bufLen:        opts.BufLen,
+		backpressure:  opts.Backpressure,
+		spill:         opts.Spill,
+		parser:        opts.Parser,
+		keepRaw:       opts.KeepRaw,
+		multiline:     opts.Multiline,
+		timeParser:    opts.TimeParser,
+		maxLineBytes:  opts.MaxLineBytes,
+		longLines:     opts.LongLines,
+		partialFlush:  opts.PartialFlush,
+		tee:           opts.Tee,
+		batchSize:     opts.BatchSize,
+		batchBytes:    opts.BatchBytes,
+		flushInterval: opts.FlushInterval,
+		src:           src,
+		logger:        logger,
+	}
+}
+
+// Name returns the Forwarder's name.
+func (f *Forwarder) Name() string {
+	return f.name
+}
+
+// Stats returns a snapshot of the Forwarder's counters. It may be called
+// concurrently with forwarding, once Forwarder.Forward has been called.
+func (f *Forwarder) Stats() Stats {
+	s := f.stats.snapshot()
+	s.QueueLen, s.QueueCap = len(f.msgs), cap(f.msgs)
+	return s
+}
+
+// Connected returns true if the Forwarder's Logger is connected. It may be
+// called concurrently with forwarding.
+func (f *Forwarder) Connected() bool {
+	return f.logger.IsConnected()
 }
 
 // Forward forwards log messages by launching two goroutines - one to read
@@ -34,27 +189,45 @@ func NewForwarder(name string, bufLen uint, src io.ReadCloser, logger Logger) *F
 // messages to the configured Logger. It returns immediately after launching
 // these goroutines. The reader goroutine passes messages to the writer
 // goroutine via a buffered channel. The channel's buffer length is determined
-// by the Forwarder's bufLen property. Note that if the buffer is full, messages
-// are unceremoniously dropped. Also note that if the underlying logger
-// connection is not established, the Logger connection will be retried on each
-// message until it can be successfully established. If the connection can't be
-// established while processing a particular message, that message will be
-// dropped. If there is an error during the Logger.Log call, it will be ignored
-// (but the error will be writen to stderr) and the log message will likely be
-// lost as well. Additionally, in the case of errors to Logger.Log, the Logger's
-// connection is explicitly disconnected and retried on the next message for
-// resiliency.
+// by the Forwarder's bufLen property. What happens when the buffer is full is
+// determined by the Forwarder's Backpressure strategy - by default, messages
+// are unceremoniously dropped. If batching is enabled, the writer accumulates
+// messages and sends them to the Logger in batches. Also note that if the
+// underlying logger connection is not established, the Logger connection will
+// be retried on each message (or batch) until it can be successfully
+// established. If the connection can't be established while processing a
+// particular message, that message will be dropped, or spilled if the
+// Forwarder has a Spill. While there are spilled messages, new messages are
+// spilled behind them, and the connection is only retried periodically. It is
+// also retried periodically while there are no new messages. If there is an
+// error during the Logger.Log call, it will be ignored (but the error will be
+// writen to stderr) and the log message will likely be lost as well, unless it
+// is spilled. Spilled messages are replayed, in order, as soon as the
+// connection is re-established.
+// Additionally, in the case of errors to Logger.Log, the Logger's connection is
+// explicitly disconnected and retried on the next message for resiliency. Use
+// Forwarder.Wait to block until all buffered messages have been processed.
 func (f *Forwarder) Forward() {
-	msgs := make(chan string, f.bufLen)
+	msgs := make(chan Message, f.bufLen)
+	f.msgs = msgs
+	f.done = make(chan struct{})
 
 	// Reader
-	go func(msgs chan<- string) {
+	go func(msgs chan Message) {
 		// When readLines returns (due to either EOF or an error) and this
 		// goroutine exits, the msgs channel is closed, which will cause the
 		// writer goroutine to exit as well.
 		defer func() {
-			close(msgs)
 			_ = f.src.Close()
+			if f.tee != nil {
+				// Finish echoing before the writer is told we're done, so that
+				// waiting for the Forwarder covers the Tee too.
+				_ = f.tee.Close()
+				if dropped := f.tee.Dropped(); dropped > 0 {
+					slog.Warn("tee fell behind; output was dropped", "name", f.name, "chunks", dropped)
+				}
+			}
+			close(msgs)
 		}()
 		if err := f.readLines(msgs); err != nil {
 			// Nothing we can really do here but log this and quit.
@@ -63,64 +236,463 @@ func (f *Forwarder) Forward() {
 	}(msgs)
 
 	// Writer
-	go func(msgs <-chan string) {
-		defer func() { _ = f.logger.Disconnect() }()
-		for msg := range msgs {
-			if !f.logger.IsConnected() {
-				// Try establishing a connection.
-				if err := f.logger.Connect(); err != nil {
-					slog.Debug("error connecting logger; dropping msg", "name", f.name, "error", err)
-					continue
-				}
-				slog.Debug("logger reconnected", "name", f.name)
-			}
-			if err := f.logger.Log(msg); err != nil {
-				// Probably lost connection, try to reconnect once and re-send
-				// the message... otherwise drop it.
-				_ = f.logger.Disconnect()
-				if err := f.logger.Connect(); err != nil {
-					slog.Debug("error connecting logger; dropping msg", "name", f.name, "error", err)
-					continue
-				}
-				slog.Debug("logger reconnected", "name", f.name)
-				if err := f.logger.Log(msg); err != nil {
-					// Still can't log; will retry on next message.
-					slog.Error("error logging msg; dropping msg", "name", f.name, "error", err)
-					_ = f.logger.Disconnect()
+	go func(msgs <-chan Message) {
+		defer func() {
+			_ = f.logger.Disconnect()
+			if c, ok := f.logger.(io.Closer); ok {
+				_ = c.Close()
+			}
+			if f.spill != nil {
+				_ = f.spill.Close()
+			}
+			close(f.done)
+		}()
+		f.writeMsgs(msgs)
+	}(msgs)
+}
+
+// writeMsgs writes messages from the channel to the Logger, either one at a
+// time or in batches, until the channel is closed.
+func (f *Forwarder) writeMsgs(msgs <-chan Message) {
+	// Periodically try to reconnect, and replay the spill if there is one, even
+	// if there are no new messages, so that an idle Forwarder recovers too.
+	retry := time.NewTicker(retryInterval)
+	defer retry.Stop()
+	if f.spill != nil {
+		f.replay()
+	}
+	var (
+		batch      []Message
+		batchBytes uint
+		flush      <-chan time.Time
+		flushTimer *time.Timer
+	)
+	flushBatch := func() {
+		if len(batch) > 0 {
+			f.write(batch)
+		}
+		batch, batchBytes, flush = nil, 0, nil
+		if flushTimer != nil {
+			flushTimer.Stop()
+		}
+	}
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				flushBatch()
+				if f.spill != nil {
+					// One last try before we go.
+					f.replay()
 				}
+				return
+			}
+			if !f.batching() {
+				f.write([]Message{msg})
+				continue
 			}
+			batch = append(batch, msg)
+			batchBytes += uint(len(msg.Line))
+			if len(batch) == 1 && f.flushInterval > 0 {
+				flushTimer = time.NewTimer(f.flushInterval)
+				flush = flushTimer.C
+			}
+			if (f.batchSize > 0 && uint(len(batch)) >= f.batchSize) ||
+				(f.batchBytes > 0 && batchBytes >= f.batchBytes) {
+				flushBatch()
+			}
+		case <-flush:
+			flushBatch()
+		case <-retry.C:
+			f.retry()
 		}
-	}(msgs)
+	}
+}
+
+// batching returns true if the Forwarder sends messages in batches rather than
+// one at a time.
+func (f *Forwarder) batching() bool {
+	return f.batchSize > 1 || f.batchBytes > 0
+}
+
+// write sends the messages to the Logger. If the messages can't be sent, they
+// are either spilled (if there is a spill) or dropped.
+func (f *Forwarder) write(msgs []Message) {
+	if f.spill != nil && !f.spill.Empty() {
+		// There are older messages waiting to be sent, so these have to get in
+		// line behind them to preserve ordering. If the Logger isn't connected,
+		// reconnecting is left to the retry ticker, so that an unresponsive
+		// destination doesn't hold up every message for the connect timeout.
+		f.spillMsgs(msgs)
+		if f.logger.IsConnected() {
+			f.replay()
+		}
+		return
+	}
+	if err := f.send(msgs); err != nil {
+		if f.spill != nil {
+			f.spillMsgs(msgs)
+			return
+		}
+		slog.Debug("error sending msgs; dropping msgs", "name", f.name, "count", len(msgs), "error", err)
+		if errors.Is(err, errConnect) {
+			f.stats.drop(DropConnectFailed, len(msgs))
+		} else {
+			f.stats.drop(DropSendFailed, len(msgs))
+		}
+	}
+}
+
+// send sends the messages to the Logger, (re)connecting it as needed. A single
+// message is sent with Logger.Log, otherwise Logger.LogBatch is used. If the
+// connection can't be established, an error wrapping errConnect is returned.
+// If the messages can't be logged even after reconnecting once, the error is
+// returned.
+func (f *Forwarder) send(msgs []Message) error {
+	log := func() error {
+		start := time.Now()
+		var err error
+		if len(msgs) == 1 {
+			err = f.logger.Log(msgs[0])
+		} else {
+			err = f.logger.LogBatch(msgs)
+		}
+		if err == nil {
+			f.stats.observe(time.Since(start))
+			f.stats.linesSent.Add(uint64(len(msgs)))
+		}
+		return err
+	}
+	if !f.logger.IsConnected() {
+		// Try establishing a connection.
+		if err := f.connect(); err != nil {
+			return err
+		}
+	}
+	if err := log(); err != nil {
+		// Probably lost connection, try to reconnect once and re-send the
+		// messages.
+		_ = f.logger.Disconnect()
+		if err := f.connect(); err != nil {
+			return err
+		}
+		if err := log(); err != nil {
+			// Still can't log; will retry on next message.
+			slog.Error("error logging msgs", "name", f.name, "error", err)
+			_ = f.logger.Disconnect()
+			return err
+		}
+	}
+	return nil
+}
+
+// connect connects the Logger. If the connection can't be established, an
+// error wrapping errConnect is returned.
+func (f *Forwarder) connect() error {
+	if err := f.logger.Connect(); err != nil {
+		return fmt.Errorf("%w: %w", errConnect, err)
+	}
+	f.stats.reconnects.Add(1)
+	slog.Debug("logger reconnected", "name", f.name)
+	return nil
+}
+
+// retry reconnects the Logger if it isn't connected, and then replays the
+// spill, if there is one.
+func (f *Forwarder) retry() {
+	if !f.logger.IsConnected() {
+		if err := f.connect(); err != nil {
+			slog.Debug("error reconnecting logger; will retry", "name", f.name, "error", err)
+			return
+		}
+	}
+	if f.spill != nil {
+		f.replay()
+	}
+}
+
+// spillMsgs adds the messages to the spill, dropping any that can't be added.
+func (f *Forwarder) spillMsgs(msgs []Message) {
+	for _, msg := range msgs {
+		if err := f.spill.Push(msg); err != nil {
+			slog.Debug("error spilling msg; dropping msg", "name", f.name, "error", err)
+			f.stats.drop(DropSpillFailed, 1)
+		}
+	}
+	if err := f.spill.Sync(); err != nil {
+		slog.Error("error syncing spill", "name", f.name, "error", err)
+	}
+}
+
+// replay sends spilled messages to the Logger in order, until either the spill
+// is empty or a message can't be sent. Messages are read from the spill, and
+// removed from it, a batch at a time. If batching is enabled, each batch is
+// sent with a single Logger.LogBatch call.
+func (f *Forwarder) replay() {
+	for {
+		msgs, err := f.spill.PeekN(f.replayLen())
+		if err != nil {
+			slog.Error("error reading spilled msgs", "name", f.name, "error", err)
+			return
+		}
+		if len(msgs) == 0 {
+			return
+		}
+		sent, err := f.sendSpilled(msgs)
+		if sent > 0 {
+			if err := f.spill.PopN(sent); err != nil {
+				slog.Error("error removing spilled msgs", "name", f.name, "error", err)
+				return
+			}
+		}
+		if err != nil {
+			slog.Debug("error sending spilled msgs; will retry", "name", f.name, "error", err)
+			return
+		}
+	}
+}
+
+// replayLen returns the maximum number of spilled messages to replay at a time.
+func (f *Forwarder) replayLen() int {
+	if f.batching() && f.batchSize > 0 {
+		return int(f.batchSize)
+	}
+	return spillReplayLen
+}
+
+// sendSpilled sends the spilled messages to the Logger, in batches if batching
+// is enabled, and returns how many of them (from the start) were sent.
+func (f *Forwarder) sendSpilled(msgs []Message) (int, error) {
+	if !f.batching() {
+		for i, msg := range msgs {
+			if err := f.send([]Message{msg}); err != nil {
+				return i, err
+			}
+		}
+		return len(msgs), nil
+	}
+	sent := 0
+	for sent < len(msgs) {
+		// Split by batch bytes, as batches are split when writing.
+		n, batchBytes := 0, uint(0)
+		for _, msg := range msgs[sent:] {
+			n++
+			batchBytes += uint(len(msg.Line))
+			if f.batchBytes > 0 && batchBytes >= f.batchBytes {
+				break
+			}
+		}
+		if err := f.send(msgs[sent : sent+n]); err != nil {
+			return sent, err
+		}
+		sent += n
+	}
+	return sent, nil
+}
+
+// Wait blocks until the Forwarder has finished processing all of its messages,
+// i.e. its source reader has reached EOF and every buffered message has been
+// handed to the Logger (or dropped), or until the given context is done,
+// whichever happens first. In the latter case, the context's error is
+// returned. Forwarder.Forward must be called before calling Wait.
+func (f *Forwarder) Wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // readLines reads lines from the Forwarder's reader, and passes them to the
 // provided message channel until there is no more input available from the
-// reader (EOF). Lines may be arbitrarily long. If the channel's buffer is
-// full, the line is dropped. If there is an error reading from the reader at
-// any point, the error is returned.
-func (f *Forwarder) readLines(msgs chan<- string) error {
-	reader := bufio.NewReader(f.src)
+// reader (EOF). If the Forwarder has a Multiline, consecutive lines are first
+// aggregated into multiline records. Each line (or record) is timestamped with
+// the time it was read (its first line, for records), and parsed by the
+// Forwarder's Parser, if it has one, before being passed on. Lines longer than
+// the Forwarder's maximum line length, if it has one, are handled according to
+// its LongLinePolicy. If the channel's buffer is full, the line is handled
+// according to the Forwarder's Backpressure strategy. If there is an error
+// reading from the reader at any point, the error is returned.
+func (f *Forwarder) readLines(msgs chan Message) error {
+	emit := func(l rawLine) {
+		f.enqueue(msgs, f.parse(l))
+	}
+	if f.multiline == nil {
+		return f.scanLines(emit)
+	}
+	// Lines are scanned in a separate goroutine, so that a pending record can
+	// be emitted once the multiline timeout expires, even while the scanner is
+	// blocked waiting for more input.
+	lines := make(chan rawLine)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		errc <- f.scanLines(func(l rawLine) {
+			lines <- l
+		})
+	}()
+	f.multiline.aggregate(lines, emit)
+	return <-errc
+}
+
+// scanLines reads lines from the Forwarder's reader, and passes each one,
+// without its trailing newline, to out until EOF. Without a maximum line
+// length, lines may be arbitrarily long. Otherwise, lines which exceed it are
+// truncated, split, or dropped, according to the Forwarder's LongLinePolicy,
+// without ever holding more than roughly the maximum length in memory. If the
+// Forwarder has a partial flush timeout, whatever has been read of a line is
+// emitted, annotated with partial=true, once nothing more has been read for
+// the timeout. Everything read is echoed to the Forwarder's Tee, if it has
+// one. If there is an error reading from the reader, the error is returned.
+func (f *Forwarder) scanLines(out func(rawLine)) error {
+	var src io.Reader = countingReader{f.src, &f.stats.bytesRead}
+	if f.tee != nil {
+		src = io.TeeReader(src, f.tee)
+	}
+	if f.partialFlush > 0 {
+		src = newIdleReader(src, f.partialFlush)
+	}
+	var (
+		reader  = bufio.NewReader(src)
+		maxLen  = int(f.maxLineBytes)
+		buf     []byte
+		discard bool   // Whether to discard the rest of the current line.
+		splitID string // The ID of the current line, if it is being split.
+		index   int    // The index of the next part of a split line.
+	)
+	emit := func(l rawLine) {
+		f.stats.linesRead.Add(1)
+		out(l)
+	}
+	// part returns the line for the next part of a split line.
+	part := func(text []byte, last bool) rawLine {
+		l := rawLine{
+			text:  string(text),
+			time:  time.Now(),
+			attrs: map[string]any{"split_id": splitID, "split_index": index, "split_last": last},
+		}
+		index++
+		return l
+	}
 	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err != io.EOF {
-				return fmt.Errorf("error reading from reader: %w", err)
+		chunk, err := reader.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull && err != io.EOF && err != errIdle {
+			return fmt.Errorf("error reading from reader: %w", err)
+		}
+		eol := err == nil
+		if !discard {
+			buf = append(buf, bytes.TrimSuffix(chunk, []byte("\n"))...)
+		}
+		for maxLen > 0 && len(buf) > maxLen {
+			cut := cutPoint(buf, maxLen)
+			switch f.longLines {
+			case LongLineSplit:
+				if splitID == "" {
+					splitID = newSplitID()
+				}
+				emit(part(buf[:cut], false))
+				buf = append(buf[:0], buf[cut:]...)
+				continue
+			case LongLineDrop:
+				slog.Debug("dropping line exceeding max length", "name", f.name, "max", maxLen)
+				f.stats.linesRead.Add(1)
+				f.stats.drop(DropTooLong, 1)
+			default:
+				emit(rawLine{text: string(buf[:cut]), time: time.Now(), attrs: map[string]any{"truncated": true}})
+			}
+			buf, discard = buf[:0], true
+		}
+		if err == errIdle && len(buf) > 0 {
+			// The rest of the line hasn't turned up in time, so send what we
+			// have so far.
+			if splitID != "" {
+				emit(part(buf, false))
+			} else {
+				emit(rawLine{text: string(buf), time: time.Now(), attrs: map[string]any{"partial": true}})
+			}
+			buf = buf[:0]
+			continue
+		}
+		// It's common to have a blank line at the end of the file, which we
+		// don't care about and can safely ignore.
+		if eol || (err == io.EOF && len(buf) > 0) {
+			switch {
+			case discard:
+			case splitID != "":
+				emit(part(buf, true))
+			default:
+				emit(rawLine{text: string(buf), time: time.Now()})
+			}
+			buf, discard, splitID, index = buf[:0], false, "", 0
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// parse returns a Message for the line, with its fields parsed by the
+// Forwarder's Parser, if it has one. If the line can't be parsed, the message
+// falls back to being just the raw line. If the Forwarder is configured to keep
+// raw lines, the line is added to the parsed fields under the "log" key. The
+// message's time is taken from its fields by the Forwarder's TimeParser, if it
+// has one and the time can be parsed, and is the time the line was read
+// otherwise. Any annotations of the line are added to the message's fields.
+func (f *Forwarder) parse(l rawLine) Message {
+	msg := Message{Line: l.text, Time: l.time}
+	if f.parser != nil {
+		fields, err := f.parser.Parse(l.text)
+		if err == nil {
+			msg.Fields = fields
+			if f.keepRaw {
+				msg.Fields["log"] = l.text
 			}
-			// It's common to have a blank line at the end of the file, which we
-			// don't care about and can safely ignore.
-			if line == "" {
-				return nil
+			if f.timeParser != nil {
+				if t, ok := f.timeParser.Time(fields); ok {
+					msg.Time = t
+				}
 			}
 		}
+	}
+	if l.attrs != nil {
+		if msg.Fields == nil {
+			msg.Fields = map[string]any{"log": l.text}
+		}
+		maps.Copy(msg.Fields, l.attrs)
+	}
+	return msg
+}
+
+// enqueue passes the message to the message channel, applying the Forwarder's
+// Backpressure strategy if the channel's buffer is full.
+func (f *Forwarder) enqueue(msgs chan Message, msg Message) {
+	switch f.backpressure {
+	case BackpressureBlock:
+		msgs <- msg
+	case BackpressureDropOldest:
+		for {
+			select {
+			case msgs <- msg:
+				return
+			default:
+			}
+			// We're running behind - evict the oldest message to make room. The
+			// writer may beat us to it, in which case we just try again.
+			select {
+			case <-msgs:
+				slog.Debug("message channel buffer is full; dropping oldest msg", "name", f.name)
+				f.stats.drop(DropBufferFull, 1)
+			default:
+			}
+		}
+	default:
 		select {
-		case msgs <- strings.TrimSuffix(line, "\n"):
+		case msgs <- msg:
 		default:
 			// We're running behind - drop the message.
 			slog.Debug("message channel buffer is full; dropping msg", "name", f.name)
-		}
-		// Reached EOF but still had a message to send. We're done now.
-		if err == io.EOF {
-			return nil
+			f.stats.drop(DropBufferFull, 1)
 		}
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -26,7 +27,7 @@ func TestForwarder_Forward_Successful(t *testing.T) {
 		Return(nil).Once()
 	logger.On("Log", mock.Anything).Run(
 		func(args mock.Arguments) {
-			ch <- args.Get(0).(string)
+			ch <- args.Get(0).(Message).Line
 		},
 	).Times(len(msgs)).Return(nil)
 	f := &Forwarder{
@@ -57,7 +58,7 @@ func TestForwarder_Forward_Disconnected_ReconnectsButDropsMidMessage(t *testing.
 	logger.On("Log", mock.Anything).
 		Run(
 			func(args mock.Arguments) {
-				ch <- args.Get(0).(string)
+				ch <- args.Get(0).(Message).Line
 			},
 		).
 		// Log should only be called twice since the second message is dropped.
@@ -95,7 +96,7 @@ func TestForwarder_Forward_ErrorDuringLogReconnectsOnce(t *testing.T) {
 	logger.On("Log", mock.Anything).
 		Run(
 			func(args mock.Arguments) {
-				ch <- args.Get(0).(string)
+				ch <- args.Get(0).(Message).Line
 			},
 		).
 		Times(3).
@@ -132,7 +133,7 @@ func TestForwarder_Forward_ErrorsDuringLogDropsMessage(t *testing.T) {
 	logger.On("Log", mock.Anything).
 		Run(
 			func(args mock.Arguments) {
-				ch <- args.Get(0).(string)
+				ch <- args.Get(0).(Message).Line
 			},
 		).
 		Twice().
@@ -164,7 +165,7 @@ func TestForwarder_Forward_BufferIsFull_DropsMessages(t *testing.T) {
 	logger.On("Log", mock.Anything).
 		Run(
 			func(args mock.Arguments) {
-				ch <- args.Get(0).(string)
+				ch <- args.Get(0).(Message).Line
 			},
 		).
 		Return(nil)
@@ -182,10 +183,55 @@ func TestForwarder_Forward_BufferIsFull_DropsMessages(t *testing.T) {
 	require.Less(t, len(actualMsgs), len(msgs))
 }
 
+func TestForwarder_Wait_ReturnsAfterAllMessagesProcessed(t *testing.T) {
+	logger := NewMockLogger(t)
+	msgs := []string{"line1", "line2", "line3"}
+	reader := strings.NewReader(strings.Join(msgs, "\n") + "\n")
+	var actualMsgs []string
+	logger.On("IsConnected").Return(true)
+	logger.On("Disconnect").Return(nil).Once()
+	logger.On("Log", mock.Anything).Run(
+		func(args mock.Arguments) {
+			actualMsgs = append(actualMsgs, args.Get(0).(Message).Line)
+		},
+	).Times(len(msgs)).Return(nil)
+	f := &Forwarder{
+		name:   "name",
+		bufLen: uint(len(msgs)),
+		src:    io.NopCloser(reader),
+		logger: logger,
+	}
+	f.Forward()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	require.NoError(t, f.Wait(ctx))
+	require.Equal(t, msgs, actualMsgs)
+}
+
+func TestForwarder_Wait_ContextDone(t *testing.T) {
+	logger := NewMockLogger(t)
+	logger.On("Disconnect").Return(nil).Once()
+	// The source is not closed until the end of the test, so the forwarder
+	// can't finish before then.
+	reader, writer := io.Pipe()
+	f := &Forwarder{
+		name:   "name",
+		bufLen: 1,
+		src:    reader,
+		logger: logger,
+	}
+	f.Forward()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, f.Wait(ctx), context.DeadlineExceeded)
+	_ = writer.Close()
+	require.NoError(t, f.Wait(context.Background()))
+}
+
 func TestNewForwarder_ConnectsLogger(t *testing.T) {
 	logger := NewMockLogger(t)
 	logger.On("Connect").Return(nil).Once()
-	f := NewForwarder("", 0, nil, logger)
+	f := NewForwarder("", nil, logger, ForwarderOptions{})
 	require.NotNil(t, f)
 	logger.AssertExpectations(t)
 }
@@ -193,7 +239,7 @@ func TestNewForwarder_ConnectsLogger(t *testing.T) {
 func TestNewForwarder_ConnectsLogger_NoError(t *testing.T) {
 	logger := NewMockLogger(t)
 	logger.On("Connect").Return(errors.New("err")).Once()
-	f := NewForwarder("", 0, nil, logger)
+	f := NewForwarder("", nil, logger, ForwarderOptions{})
 	require.NotNil(t, f)
 	logger.AssertExpectations(t)
 }
@@ -202,14 +248,14 @@ func TestForwarder_readLines_Success(t *testing.T) {
 	msgs := []string{"1", "2", "3"}
 	reader := strings.NewReader(strings.Join(msgs, "\n") + "\n")
 	f := &Forwarder{name: "dummy", src: io.NopCloser(reader)}
-	ch := make(chan string, len(msgs)+1)
+	ch := make(chan Message, len(msgs)+1)
 	go func() {
 		defer close(ch)
 		_ = f.readLines(ch)
 	}()
 	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
 	defer cancel()
-	actualMsgs := readChan(ctx, ch)
+	actualMsgs := lines(readChan(ctx, ch))
 	require.ElementsMatch(t, msgs, actualMsgs)
 }
 
@@ -217,14 +263,14 @@ func TestForwarder_readLines_SuccessNoNewLineAtEOF(t *testing.T) {
 	msgs := []string{"1", "2", "3"}
 	reader := strings.NewReader(strings.Join(msgs, "\n"))
 	f := &Forwarder{name: "dummy", src: io.NopCloser(reader)}
-	ch := make(chan string, len(msgs)+1)
+	ch := make(chan Message, len(msgs)+1)
 	go func() {
 		defer close(ch)
 		_ = f.readLines(ch)
 	}()
 	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
 	defer cancel()
-	actualMsgs := readChan(ctx, ch)
+	actualMsgs := lines(readChan(ctx, ch))
 	require.ElementsMatch(t, msgs, actualMsgs)
 }
 
@@ -232,17 +278,524 @@ func TestForwarder_readLines_LargeLine_Success(t *testing.T) {
 	msgs := []string{"1", largeString(bufio.MaxScanTokenSize + 1), "2", "3"}
 	reader := strings.NewReader(strings.Join(msgs, "\n") + "\n")
 	f := &Forwarder{name: "dummy", src: io.NopCloser(reader)}
-	ch := make(chan string, len(msgs))
+	ch := make(chan Message, len(msgs))
 	go func() {
 		defer close(ch)
 		_ = f.readLines(ch)
 	}()
 	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
 	defer cancel()
-	actualMsgs := readChan(ctx, ch)
+	actualMsgs := lines(readChan(ctx, ch))
 	require.ElementsMatch(t, msgs, actualMsgs)
 }
 
+func TestForwarder_readLines_ParsesLines(t *testing.T) {
+	reader := strings.NewReader("{\"msg\":\"hello\"}\nnot json\n")
+	f := &Forwarder{name: "dummy", parser: JSONParser{}, src: io.NopCloser(reader)}
+	ch := make(chan Message, 2)
+	require.NoError(t, f.readLines(ch))
+	close(ch)
+	actualMsgs := untimed(t, readChan(context.Background(), ch))
+	expectedMsgs := []Message{
+		{Line: `{"msg":"hello"}`, Fields: map[string]any{"msg": "hello"}},
+		// Falls back to the plain line.
+		{Line: "not json"},
+	}
+	require.Equal(t, expectedMsgs, actualMsgs)
+}
+
+func TestForwarder_readLines_KeepRaw(t *testing.T) {
+	reader := strings.NewReader("level=info msg=hello\nnot logfmt\n")
+	f := &Forwarder{name: "dummy", parser: LogfmtParser{}, keepRaw: true, src: io.NopCloser(reader)}
+	ch := make(chan Message, 2)
+	require.NoError(t, f.readLines(ch))
+	close(ch)
+	actualMsgs := untimed(t, readChan(context.Background(), ch))
+	expectedMsgs := []Message{
+		{
+			Line:   "level=info msg=hello",
+			Fields: map[string]any{"level": "info", "msg": "hello", "log": "level=info msg=hello"},
+		},
+		{Line: "not logfmt"},
+	}
+	require.Equal(t, expectedMsgs, actualMsgs)
+}
+
+func TestForwarder_readLines_Multiline(t *testing.T) {
+	reader := strings.NewReader("{\"msg\":\"a\"}\n  trace\n{\"msg\":\"b\"}\n")
+	m, err := NewMultilinePreset("java", time.Minute)
+	require.NoError(t, err)
+	f := &Forwarder{name: "dummy", multiline: m, src: io.NopCloser(reader)}
+	ch := make(chan Message, 2)
+	require.NoError(t, f.readLines(ch))
+	close(ch)
+	require.Equal(t, []string{"{\"msg\":\"a\"}\n  trace", "{\"msg\":\"b\"}"}, lines(readChan(context.Background(), ch)))
+}
+
+func TestForwarder_readLines_ParsesTime(t *testing.T) {
+	reader := strings.NewReader(
+		"{\"msg\":\"a\",\"ts\":\"2024-03-01T12:30:45.5Z\"}\n{\"msg\":\"b\",\"ts\":\"invalid\"}\nplain\n",
+	)
+	tp, err := NewTimeParser("ts", TimeFormatRFC3339)
+	require.NoError(t, err)
+	f := &Forwarder{name: "dummy", parser: JSONParser{}, timeParser: tp, src: io.NopCloser(reader)}
+	ch := make(chan Message, 3)
+	start := time.Now()
+	require.NoError(t, f.readLines(ch))
+	close(ch)
+	actualMsgs := readChan(context.Background(), ch)
+	require.Len(t, actualMsgs, 3)
+	require.True(t, time.Date(2024, 3, 1, 12, 30, 45, 500000000, time.UTC).Equal(actualMsgs[0].Time))
+	// Falls back to the time the line was read.
+	require.False(t, actualMsgs[1].Time.Before(start))
+	require.False(t, actualMsgs[2].Time.Before(start))
+}
+
+func TestForwarder_readLines_MaxLineBytes(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy LongLinePolicy
+		input  string
+		want   []Message
+	}{
+		{
+			name:  "truncate is the default",
+			input: "hello world\nhi\n",
+			want: []Message{
+				{Line: "hello", Fields: map[string]any{"log": "hello", "truncated": true}},
+				{Line: "hi"},
+			},
+		},
+		{
+			name:   "truncate doesn't split characters",
+			policy: LongLineTruncate,
+			input:  "abcdé\n",
+			want: []Message{
+				{Line: "abcd", Fields: map[string]any{"log": "abcd", "truncated": true}},
+			},
+		},
+		{
+			name:   "drop",
+			policy: LongLineDrop,
+			input:  "hello world\nhi\nworld hello",
+			want:   []Message{{Line: "hi"}},
+		},
+		{
+			name:   "exactly the max length",
+			policy: LongLineDrop,
+			input:  "hello\n",
+			want:   []Message{{Line: "hello"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				f := &Forwarder{
+					name:         "dummy",
+					maxLineBytes: 5,
+					longLines:    tt.policy,
+					src:          io.NopCloser(strings.NewReader(tt.input)),
+				}
+				ch := make(chan Message, 10)
+				require.NoError(t, f.readLines(ch))
+				close(ch)
+				require.Equal(t, tt.want, untimed(t, readChan(context.Background(), ch)))
+			},
+		)
+	}
+}
+
+func TestForwarder_readLines_MaxLineBytes_Split(t *testing.T) {
+	long := largeString(10000)
+	reader := strings.NewReader(long + "\nhi\n" + long)
+	f := &Forwarder{name: "dummy", maxLineBytes: 4096, longLines: LongLineSplit, src: io.NopCloser(reader)}
+	ch := make(chan Message, 10)
+	require.NoError(t, f.readLines(ch))
+	close(ch)
+	msgs := untimed(t, readChan(context.Background(), ch))
+	require.Len(t, msgs, 7)
+	require.Equal(t, Message{Line: "hi"}, msgs[3])
+	for _, parts := range [][]Message{msgs[:3], msgs[4:]} {
+		id := parts[0].Fields["split_id"]
+		require.NotEmpty(t, id)
+		var line string
+		for i, part := range parts {
+			require.Equal(t, id, part.Fields["split_id"])
+			require.Equal(t, i, part.Fields["split_index"])
+			require.Equal(t, i == len(parts)-1, part.Fields["split_last"])
+			require.Equal(t, part.Line, part.Fields["log"])
+			require.LessOrEqual(t, len(part.Line), 4096)
+			line += part.Line
+		}
+		require.Equal(t, long, line)
+	}
+	require.NotEqual(t, msgs[0].Fields["split_id"], msgs[4].Fields["split_id"])
+}
+
+func TestForwarder_readLines_PartialFlush(t *testing.T) {
+	r, w := io.Pipe()
+	f := &Forwarder{name: "dummy", partialFlush: 10 * time.Millisecond, src: r}
+	ch := make(chan Message, 10)
+	errc := make(chan error, 1)
+	go func() { errc <- f.readLines(ch) }()
+	_, err := w.Write([]byte("complete\nprogress: 50%"))
+	require.NoError(t, err)
+	// The partial line is sent once the writer has been idle for a while.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, want := range []Message{
+		{Line: "complete"},
+		{Line: "progress: 50%", Fields: map[string]any{"log": "progress: 50%", "partial": true}},
+	} {
+		select {
+		case msg := <-ch:
+			require.Equal(t, want, untimed(t, []Message{msg})[0], "message %d", i)
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for message")
+		}
+	}
+	// The rest of the line is sent as a regular line.
+	_, err = w.Write([]byte(" done\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, <-errc)
+	close(ch)
+	require.Equal(t, []string{" done"}, lines(readChan(ctx, ch)))
+}
+
+func TestLongLinePolicy_UnmarshalText(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    LongLinePolicy
+		wantErr require.ErrorAssertionFunc
+	}{
+		{text: "truncate", want: LongLineTruncate, wantErr: require.NoError},
+		{text: "split", want: LongLineSplit, wantErr: require.NoError},
+		{text: "drop", want: LongLineDrop, wantErr: require.NoError},
+		{text: "invalid", wantErr: require.Error},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.text, func(t *testing.T) {
+				var p LongLinePolicy
+				tt.wantErr(t, p.UnmarshalText([]byte(tt.text)))
+				require.Equal(t, tt.want, p)
+			},
+		)
+	}
+}
+
+func TestForwarder_readLines_BackpressureDrop_DropsNewest(t *testing.T) {
+	reader := strings.NewReader("1\n2\n3\n")
+	f := &Forwarder{name: "dummy", backpressure: BackpressureDrop, src: io.NopCloser(reader)}
+	ch := make(chan Message, 2)
+	require.NoError(t, f.readLines(ch))
+	close(ch)
+	actualMsgs := lines(readChan(context.Background(), ch))
+	require.Equal(t, []string{"1", "2"}, actualMsgs)
+}
+
+func TestForwarder_readLines_BackpressureDropOldest_DropsOldest(t *testing.T) {
+	reader := strings.NewReader("1\n2\n3\n4\n")
+	f := &Forwarder{name: "dummy", backpressure: BackpressureDropOldest, src: io.NopCloser(reader)}
+	ch := make(chan Message, 2)
+	require.NoError(t, f.readLines(ch))
+	close(ch)
+	actualMsgs := lines(readChan(context.Background(), ch))
+	require.Equal(t, []string{"3", "4"}, actualMsgs)
+}
+
+func TestForwarder_readLines_BackpressureBlock_DoesNotDrop(t *testing.T) {
+	msgs := []string{"1", "2", "3", "4"}
+	reader := strings.NewReader(strings.Join(msgs, "\n") + "\n")
+	f := &Forwarder{name: "dummy", backpressure: BackpressureBlock, src: io.NopCloser(reader)}
+	// The buffer can only hold a single message, so the reader must block
+	// until we consume them.
+	ch := make(chan Message, 1)
+	go func() {
+		defer close(ch)
+		_ = f.readLines(ch)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	actualMsgs := lines(readChan(ctx, ch))
+	require.Equal(t, msgs, actualMsgs)
+}
+
+func TestForwarder_Forward_BackpressureBlock_SlowLogger_DoesNotDrop(t *testing.T) {
+	logger := NewMockLogger(t)
+	msgs := []string{"line1", "line2", "line3", "line4", "line5"}
+	reader := strings.NewReader(strings.Join(msgs, "\n") + "\n")
+	var actualMsgs []string
+	logger.On("IsConnected").Return(true)
+	logger.On("Disconnect").Return(nil).Once()
+	logger.On("Log", mock.Anything).Run(
+		func(args mock.Arguments) {
+			time.Sleep(5 * time.Millisecond)
+			actualMsgs = append(actualMsgs, args.Get(0).(Message).Line)
+		},
+	).Times(len(msgs)).Return(nil)
+	f := &Forwarder{
+		name:         "name",
+		bufLen:       1,
+		backpressure: BackpressureBlock,
+		src:          io.NopCloser(reader),
+		logger:       logger,
+	}
+	f.Forward()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	require.NoError(t, f.Wait(ctx))
+	require.Equal(t, msgs, actualMsgs)
+}
+
+func TestForwarder_Forward_ConnectFails_SpillsMessages(t *testing.T) {
+	logger := NewMockLogger(t)
+	msgs := []string{"line1", "line2", "line3"}
+	reader := strings.NewReader(strings.Join(msgs, "\n") + "\n")
+	logger.On("IsConnected").Return(false)
+	logger.On("Connect").Return(errors.New("error"))
+	logger.On("Disconnect").Return(nil).Once()
+	dir := t.TempDir()
+	spill, err := NewSpill(dir, 0)
+	require.NoError(t, err)
+	f := &Forwarder{
+		name:   "name",
+		bufLen: uint(len(msgs)),
+		spill:  spill,
+		src:    io.NopCloser(reader),
+		logger: logger,
+	}
+	f.Forward()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	require.NoError(t, f.Wait(ctx))
+	// Nothing was sent, but everything is persisted in order.
+	logger.AssertNotCalled(t, "Log", mock.Anything)
+	// Once the first message was spilled, the rest were spilled behind it
+	// without reconnecting, until the last try on exit.
+	logger.AssertNumberOfCalls(t, "Connect", 2)
+	spill, err = NewSpill(dir, 0)
+	require.NoError(t, err)
+	defer func() { _ = spill.Close() }()
+	require.Equal(t, msgs, lines(drainSpill(t, spill)))
+}
+
+func TestForwarder_Forward_ReconnectsWhileIdle(t *testing.T) {
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = 10 * time.Millisecond
+	logger := NewMockLogger(t)
+	var connected atomic.Bool
+	logger.On("IsConnected").Return(func() bool { return connected.Load() })
+	// The destination comes back after the first attempt.
+	logger.On("Connect").Return(errors.New("error")).Once()
+	logger.On("Connect").Run(func(mock.Arguments) { connected.Store(true) }).Return(nil).Once()
+	logger.On("Disconnect").Return(nil).Once()
+	src, w := io.Pipe()
+	f := &Forwarder{
+		name:   "name",
+		src:    src,
+		logger: logger,
+	}
+	f.Forward()
+	// No messages are sent in the meantime.
+	require.Eventually(t, f.Connected, testTimeout, retryInterval)
+	require.EqualValues(t, 1, f.Stats().Reconnects)
+	require.NoError(t, w.Close())
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	require.NoError(t, f.Wait(ctx))
+}
+
+func TestForwarder_Forward_ReplaysSpilledMessagesInOrder(t *testing.T) {
+	logger := NewMockLogger(t)
+	spilled := []string{"old1", "old2"}
+	msgs := []string{"line1", "line2"}
+	reader := strings.NewReader(strings.Join(msgs, "\n") + "\n")
+	var actualMsgs []string
+	logger.On("IsConnected").Return(true)
+	logger.On("Disconnect").Return(nil).Once()
+	logger.On("Log", mock.Anything).Run(
+		func(args mock.Arguments) {
+			actualMsgs = append(actualMsgs, args.Get(0).(Message).Line)
+		},
+	).Return(nil)
+	// Simulate messages left over from a previous run.
+	spill, err := NewSpill(t.TempDir(), 0)
+	require.NoError(t, err)
+	for _, line := range spilled {
+		require.NoError(t, spill.Push(Message{Line: line}))
+	}
+	f := &Forwarder{
+		name:   "name",
+		bufLen: uint(len(msgs)),
+		spill:  spill,
+		src:    io.NopCloser(reader),
+		logger: logger,
+	}
+	f.Forward()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	require.NoError(t, f.Wait(ctx))
+	require.Equal(t, append(spilled, msgs...), actualMsgs)
+	require.True(t, spill.Empty())
+}
+
+func TestForwarder_Forward_ReplaysSpilledMessagesInBatches(t *testing.T) {
+	logger := NewMockLogger(t)
+	var batches [][]string
+	logger.On("IsConnected").Return(true)
+	logger.On("Disconnect").Return(nil).Once()
+	logger.On("LogBatch", mock.Anything).Run(
+		func(args mock.Arguments) {
+			batches = append(batches, lines(args.Get(0).([]Message)))
+		},
+	).Return(nil)
+	logger.On("Log", mock.Anything).Run(
+		func(args mock.Arguments) {
+			batches = append(batches, []string{args.Get(0).(Message).Line})
+		},
+	).Return(nil)
+	spill, err := NewSpill(t.TempDir(), 0)
+	require.NoError(t, err)
+	for _, line := range []string{"old1", "old2", "old3"} {
+		require.NoError(t, spill.Push(Message{Line: line}))
+	}
+	f := &Forwarder{
+		name:      "name",
+		spill:     spill,
+		batchSize: 2,
+		src:       io.NopCloser(strings.NewReader("")),
+		logger:    logger,
+	}
+	f.Forward()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	require.NoError(t, f.Wait(ctx))
+	require.Equal(t, [][]string{{"old1", "old2"}, {"old3"}}, batches)
+	require.True(t, spill.Empty())
+}
+
+func TestForwarder_Forward_Batching(t *testing.T) {
+	msgs := []string{"line1", "line2", "line3", "line4", "line5"}
+	tests := []struct {
+		name        string
+		batchSize   uint
+		batchBytes  uint
+		wantBatches [][]string
+	}{
+		{
+			name:      "flushes by batch size and remainder on EOF",
+			batchSize: 2,
+			wantBatches: [][]string{
+				{"line1", "line2"},
+				{"line3", "line4"},
+				// A single message is sent with Log rather than LogBatch.
+				{"line5"},
+			},
+		},
+		{
+			name:       "flushes by batch bytes",
+			batchSize:  100,
+			batchBytes: 15,
+			wantBatches: [][]string{
+				{"line1", "line2", "line3"},
+				{"line4", "line5"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				logger := NewMockLogger(t)
+				reader := strings.NewReader(strings.Join(msgs, "\n") + "\n")
+				var batches [][]string
+				logger.On("IsConnected").Return(true)
+				logger.On("Disconnect").Return(nil).Once()
+				logger.On("LogBatch", mock.Anything).Run(
+					func(args mock.Arguments) {
+						batches = append(batches, lines(args.Get(0).([]Message)))
+					},
+				).Return(nil).Maybe()
+				logger.On("Log", mock.Anything).Run(
+					func(args mock.Arguments) {
+						batches = append(batches, []string{args.Get(0).(Message).Line})
+					},
+				).Return(nil).Maybe()
+				f := &Forwarder{
+					name:       "name",
+					bufLen:     uint(len(msgs)),
+					batchSize:  tt.batchSize,
+					batchBytes: tt.batchBytes,
+					src:        io.NopCloser(reader),
+					logger:     logger,
+				}
+				f.Forward()
+				ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+				defer cancel()
+				require.NoError(t, f.Wait(ctx))
+				require.Equal(t, tt.wantBatches, batches)
+			},
+		)
+	}
+}
+
+func TestForwarder_Forward_Batching_FlushesAfterInterval(t *testing.T) {
+	logger := NewMockLogger(t)
+	reader, writer := io.Pipe()
+	ch := make(chan []string)
+	logger.On("IsConnected").Return(true)
+	logger.On("Disconnect").Return(nil).Once()
+	logger.On("LogBatch", mock.Anything).Run(
+		func(args mock.Arguments) {
+			ch <- lines(args.Get(0).([]Message))
+		},
+	).Return(nil).Once()
+	f := &Forwarder{
+		name:          "name",
+		bufLen:        10,
+		batchSize:     10,
+		flushInterval: 10 * time.Millisecond,
+		src:           reader,
+		logger:        logger,
+	}
+	f.Forward()
+	// The batch isn't full and the source is still open, so only the flush
+	// interval can cause the batch to be sent.
+	_, err := writer.Write([]byte("line1\nline2\n"))
+	require.NoError(t, err)
+	select {
+	case batch := <-ch:
+		require.Equal(t, []string{"line1", "line2"}, batch)
+	case <-time.After(testTimeout):
+		require.Fail(t, "timed out waiting for batch")
+	}
+	require.NoError(t, writer.Close())
+	require.NoError(t, f.Wait(context.Background()))
+}
+
+func TestBackpressure_UnmarshalText(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    Backpressure
+		wantErr require.ErrorAssertionFunc
+	}{
+		{text: "drop", want: BackpressureDrop, wantErr: require.NoError},
+		{text: "block", want: BackpressureBlock, wantErr: require.NoError},
+		{text: "drop-oldest", want: BackpressureDropOldest, wantErr: require.NoError},
+		{text: "foo", wantErr: require.Error},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.text, func(t *testing.T) {
+				var bp Backpressure
+				tt.wantErr(t, bp.UnmarshalText([]byte(tt.text)))
+				require.Equal(t, tt.want, bp)
+			},
+		)
+	}
+}
+
 func largeString(n int) string {
 	var b strings.Builder
 	for i := 0; i < n; i++ {
@@ -251,8 +804,8 @@ func largeString(n int) string {
 	return b.String()
 }
 
-func readChan(ctx context.Context, ch <-chan string) []string {
-	msgs := make([]string, 0)
+func readChan[T any](ctx context.Context, ch <-chan T) []T {
+	msgs := make([]T, 0)
 	for {
 		select {
 		case <-ctx.Done():
@@ -265,3 +818,22 @@ func readChan(ctx context.Context, ch <-chan string) []string {
 		}
 	}
 }
+
+// lines returns the raw lines of the given messages.
+func lines(msgs []Message) []string {
+	lines := make([]string, len(msgs))
+	for i, msg := range msgs {
+		lines[i] = msg.Line
+	}
+	return lines
+}
+
+// untimed checks that the given messages have times, and returns them with
+// their times cleared, so they can be compared with messages without times.
+func untimed(t *testing.T, msgs []Message) []Message {
+	for i := range msgs {
+		require.False(t, msgs[i].Time.IsZero())
+		msgs[i].Time = time.Time{}
+	}
+	return msgs
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"time"
+	"unicode/utf8"
+)
+
+// The size of the chunks an idleReader reads from its source.
+const idleReaderChunkSize = 32 << 10
+
+// errIdle is returned by idleReader.Read when no data has been read for the
+// reader's timeout.
+var errIdle = errors.New("reader is idle")
+
+// LongLinePolicy is what a Forwarder does with lines longer than its maximum
+// line length.
+type LongLinePolicy string
+
+const (
+	// LongLineTruncate sends the beginning of the line, up to the maximum
+	// length, and discards the rest. The record is annotated with
+	// truncated=true. This is the default.
+	LongLineTruncate LongLinePolicy = "truncate"
+	// LongLineSplit sends the line as multiple records, each up to the maximum
+	// length. Each record is annotated with a split_id shared by all the parts
+	// of the line, its split_index within the line (from 0), and whether it is
+	// the split_last part, so that the line can be reassembled downstream.
+	LongLineSplit LongLinePolicy = "split"
+	// LongLineDrop discards the line entirely.
+	LongLineDrop LongLinePolicy = "drop"
+)
+
+// MarshalText implements encoding.TextMarshaler.
+func (p LongLinePolicy) MarshalText() ([]byte, error) {
+	return []byte(p), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It returns an error if the
+// text is not a valid LongLinePolicy.
+func (p *LongLinePolicy) UnmarshalText(text []byte) error {
+	switch policy := LongLinePolicy(text); policy {
+	case LongLineTruncate, LongLineSplit, LongLineDrop:
+		*p = policy
+		return nil
+	default:
+		return fmt.Errorf("invalid long line policy %q", text)
+	}
+}
+
+// rawLine is a line (or multiline record) read by a Forwarder, before it is
+// parsed.
+type rawLine struct {
+	text  string         // The line, without the trailing newline.
+	time  time.Time      // When the line was read.
+	attrs map[string]any // Annotations added to the line's record, if any.
+}
+
+// join returns the lines joined into a single multiline record, which has the
+// time of the first line and the annotations of all the lines.
+func join(lines []rawLine) rawLine {
+	joined := rawLine{time: lines[0].time}
+	n := len(lines) - 1
+	for _, l := range lines {
+		n += len(l.text)
+	}
+	b := make([]byte, 0, n)
+	for i, l := range lines {
+		if i > 0 {
+			b = append(b, '\n')
+		}
+		b = append(b, l.text...)
+		if l.attrs != nil {
+			if joined.attrs == nil {
+				joined.attrs = make(map[string]any)
+			}
+			maps.Copy(joined.attrs, l.attrs)
+		}
+	}
+	joined.text = string(b)
+	return joined
+}
+
+// cutPoint returns the position at which to cut b so that the first part is at
+// most n bytes long, without splitting a UTF-8 encoded character if possible.
+func cutPoint(b []byte, n int) int {
+	if len(b) <= n {
+		return len(b)
+	}
+	for i := n; i > 0 && i > n-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			return i
+		}
+	}
+	return n
+}
+
+// newSplitID returns a random ID identifying the parts of a split line.
+func newSplitID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// idleReader is an io.Reader which reads from its source in the background, and
+// returns errIdle from Read if no data is read from the source within its
+// timeout. Reading can continue after errIdle is returned.
+type idleReader struct {
+	timeout time.Duration
+	chunks  chan idleChunk
+	pending []byte // Data read from the source, but not by Read yet.
+	err     error  // The error which stopped reading from the source.
+}
+
+// idleChunk is the result of a single read from an idleReader's source.
+type idleChunk struct {
+	data []byte
+	err  error
+}
+
+// newIdleReader returns an idleReader for r with the given timeout. It reads
+// from r until r returns an error (e.g. EOF).
+func newIdleReader(r io.Reader, timeout time.Duration) *idleReader {
+	ir := &idleReader{timeout: timeout, chunks: make(chan idleChunk, 1)}
+	go func() {
+		buf := make([]byte, idleReaderChunkSize)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				ir.chunks <- idleChunk{data: append([]byte(nil), buf[:n]...)}
+			}
+			if err != nil {
+				ir.chunks <- idleChunk{err: err}
+				return
+			}
+		}
+	}()
+	return ir
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		timer := time.NewTimer(r.timeout)
+		defer timer.Stop()
+		select {
+		case chunk := <-r.chunks:
+			if chunk.err != nil {
+				r.err = chunk.err
+				return 0, r.err
+			}
+			r.pending = chunk.data
+		case <-timer.C:
+			return 0, errIdle
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
@@ -1,18 +1,83 @@
 package internal
 
 import (
+	"crypto/tls"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/IBM/fluent-forward-go/fluent/client"
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
 )
 
+// BatchMode is the Forward protocol event mode used to send batches of
+// messages.
+type BatchMode string
+
+const (
+	// BatchModeForward sends batches as Forward mode events, i.e. an array of
+	// entries.
+	BatchModeForward BatchMode = "forward"
+	// BatchModePacked sends batches as PackedForward mode events, i.e. a binary
+	// stream of entries. This is the default.
+	BatchModePacked BatchMode = "packed"
+)
+
+// MarshalText implements encoding.TextMarshaler.
+func (m BatchMode) MarshalText() ([]byte, error) {
+	return []byte(m), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It returns an error if the
+// text is not a valid BatchMode.
+func (m *BatchMode) UnmarshalText(text []byte) error {
+	switch mode := BatchMode(text); mode {
+	case BatchModeForward, BatchModePacked:
+		*m = mode
+		return nil
+	default:
+		return fmt.Errorf("invalid batch mode %q", text)
+	}
+}
+
+// Compression is the compression applied to messages sent to Fluent.
+type Compression string
+
+const (
+	// CompressionNone sends messages uncompressed. This is the default.
+	CompressionNone Compression = "none"
+	// CompressionGzip sends messages as gzip compressed CompressedPackedForward
+	// mode events.
+	CompressionGzip Compression = "gzip"
+)
+
+// MarshalText implements encoding.TextMarshaler.
+func (c Compression) MarshalText() ([]byte, error) {
+	return []byte(c), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It returns an error if the
+// text is not a valid Compression.
+func (c *Compression) UnmarshalText(text []byte) error {
+	switch comp := Compression(text); comp {
+	case CompressionNone, CompressionGzip:
+		*c = comp
+		return nil
+	default:
+		return fmt.Errorf("invalid compression %q", text)
+	}
+}
+
 // Logger is the interface that represents a type that writes log messages to
 // some (remote) destination which requires a connection.
 //
 //go:generate mockery --inpackage --name=Logger --filename mock_logger.go
 type Logger interface {
 	// Log writes the given message to the destination.
-	Log(msg string) error
+	Log(msg Message) error
+	// LogBatch writes the given messages to the destination in as few writes
+	// as possible.
+	LogBatch(msgs []Message) error
 	// Connect establishes the Logger's connection to the destination.
 	Connect() error
 	// Disconnect breaks the connection to the destination. If there is no
@@ -23,61 +88,160 @@ type Logger interface {
 }
 
 // FluentLogger is an implementation of Logger that writes a given
-// string to a configured fluent address. It is not thread safe.
+// Message to a configured fluent address. If acknowledgements are required, a
+// successful call to Log or LogBatch means that the server has received the
+// message(s). Otherwise, it only means that they were written to the
+// connection. It is not thread safe.
 type FluentLogger struct {
 	tag, stream string
 	extra       map[string]string
+	batchMode   BatchMode
+	compression Compression
 	c           client.MessageClient
-	connected   bool
+	connected   atomic.Bool
+}
+
+// FluentLoggerOptions holds the optional settings of a FluentLogger.
+type FluentLoggerOptions struct {
+	// BatchMode is the event mode used by FluentLogger.LogBatch. Defaults to
+	// BatchModePacked.
+	BatchMode BatchMode
+	// Compression is the compression applied to messages. If it is
+	// CompressionGzip, all messages are sent as CompressedPackedForward mode
+	// events, regardless of BatchMode. Defaults to CompressionNone.
+	Compression Compression
+	// RequireAck, if true, attaches a chunk ID to each message and waits for
+	// the server to acknowledge it. A message that isn't acknowledged within
+	// AckTimeout is considered to have failed.
+	RequireAck bool
+	// AckTimeout is the maximum time to wait for an acknowledgement when
+	// RequireAck is true. Defaults to client.DefaultConnectionTimeout.
+	AckTimeout time.Duration
+	// ConnectTimeout is the maximum time to wait for a connection (including
+	// the TLS handshake) to be established. Zero means no timeout, other than
+	// the operating system's.
+	ConnectTimeout time.Duration
+	// TLSConfig is the TLS configuration used when the network is "tls". If it
+	// is nil, the default configuration is used.
+	TLSConfig *tls.Config
+	// Auth, if not nil, holds the credentials used to perform the Forward
+	// protocol handshake on each new connection.
+	Auth *AuthOptions
 }
 
 // NewFluentLogger instantiates a new FluentLogger. Note that it does not
 // automatically connect the logger. Therefore, FluentLogger.Connect should be
-// called before any calls to FluentLogger.Log.
-func NewFluentLogger(network, addr, tag, stream string, extra map[string]string) *FluentLogger {
+// called before any calls to FluentLogger.Log. The network may be any network
+// supported by net.Dial, or "tls" for a TLS connection over TCP. If auth
+// options are given, the Forward protocol handshake is performed every time
+// the logger connects.
+func NewFluentLogger(
+	network, addr, tag, stream string,
+	extra map[string]string,
+	opts FluentLoggerOptions,
+) *FluentLogger {
+	connFactory := &client.ConnFactory{Network: network, Address: addr, Timeout: opts.ConnectTimeout}
+	if network == "tls" {
+		connFactory.Network = "tcp"
+		connFactory.TLSConfig = opts.TLSConfig
+		if connFactory.TLSConfig == nil {
+			connFactory.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+	}
+	var factory client.ConnectionFactory = connFactory
+	if opts.Auth != nil {
+		factory = &authConnFactory{ConnectionFactory: connFactory, opts: *opts.Auth}
+	}
 	return &FluentLogger{
-		tag:    tag,
-		stream: stream,
-		extra:  extra,
+		tag:         tag,
+		stream:      stream,
+		extra:       extra,
+		batchMode:   opts.BatchMode,
+		compression: opts.Compression,
 		c: client.New(
 			client.ConnectionOptions{
-				Factory: &client.ConnFactory{
-					Network: network,
-					Address: addr,
-				},
+				Factory:           factory,
+				RequireAck:        opts.RequireAck,
+				ConnectionTimeout: opts.AckTimeout,
 			},
 		),
 	}
 }
 
-// Log sends a given string as a message to the fluent address this logger is
+// Log sends a given Message to the fluent address this logger is
+// connected to. If the logger is not connected for some reason, call Connect
+// first. If compression is enabled, the message is sent as a batch of one.
+func (w *FluentLogger) Log(msg Message) error {
+	if w.compression == CompressionGzip {
+		return w.LogBatch([]Message{msg})
+	}
+	return w.c.Send(
+		&protocol.MessageExt{Tag: w.tag, Timestamp: eventTime(msg), Record: w.record(msg)},
+	)
+}
+
+// LogBatch sends the given Messages as a single Forward or PackedForward mode
+// message, depending on the logger's BatchMode, or as a CompressedPackedForward
+// mode message if compression is enabled, to the fluent address this logger is
 // connected to. If the logger is not connected for some reason, call Connect
 // first.
-func (w *FluentLogger) Log(msg string) error {
-	record := map[string]string{
-		"log":    msg,
-		"stream": w.stream,
+func (w *FluentLogger) LogBatch(msgs []Message) error {
+	entries := make(protocol.EntryList, len(msgs))
+	for i, msg := range msgs {
+		entries[i] = protocol.EntryExt{Timestamp: eventTime(msg), Record: w.record(msg)}
 	}
-	for k, v := range w.extra {
-		record[k] = v
+	if w.compression == CompressionGzip {
+		return w.c.SendCompressed(w.tag, entries)
+	}
+	if w.batchMode == BatchModeForward {
+		return w.c.SendForward(w.tag, entries)
 	}
-	return w.c.SendMessage(w.tag, record)
+	return w.c.SendPacked(w.tag, entries)
 }
 
 func (w *FluentLogger) Connect() error {
 	if err := w.c.Reconnect(); err != nil {
-		w.connected = false
+		w.connected.Store(false)
 		return fmt.Errorf("error connecting logger: %w", err)
 	}
-	w.connected = true
+	w.connected.Store(true)
 	return nil
 }
 
 func (w *FluentLogger) Disconnect() error {
-	w.connected = false
+	w.connected.Store(false)
 	return w.c.Disconnect()
 }
 
 func (w *FluentLogger) IsConnected() bool {
-	return w.connected
+	return w.connected.Load()
+}
+
+// record returns the Fluent record for the message. If the message has
+// fields, the record is made up of those fields. Otherwise, the record holds
+// the raw line under the "log" key. Either way, the stream name and extra
+// attributes are added to the record, overriding any fields with the same
+// keys.
+func (w *FluentLogger) record(msg Message) map[string]any {
+	record := make(map[string]any, len(msg.Fields)+len(w.extra)+2)
+	if msg.Fields == nil {
+		record["log"] = msg.Line
+	}
+	for k, v := range msg.Fields {
+		record[k] = v
+	}
+	record["stream"] = w.stream
+	for k, v := range w.extra {
+		record[k] = v
+	}
+	return record
+}
+
+// eventTime returns the message's time as a nanosecond precision EventTime, or
+// the current time if the message doesn't have one.
+func eventTime(msg Message) protocol.EventTime {
+	if msg.Time.IsZero() {
+		return protocol.EventTimeNow()
+	}
+	return protocol.EventTime{Time: msg.Time.UTC()}
 }
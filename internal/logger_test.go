@@ -2,11 +2,18 @@ package internal
 
 import (
 	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/IBM/fluent-forward-go/fluent/client"
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
 )
 
 type mockMessageClient struct {
@@ -24,11 +31,36 @@ func (m *mockMessageClient) Disconnect() error {
 	return args.Error(0)
 }
 
-func (m *mockMessageClient) SendMessage(tag string, record any) error {
-	args := m.Called(tag, record)
+func (m *mockMessageClient) SendForward(tag string, entries protocol.EntryList) error {
+	args := m.Called(tag, entries)
 	return args.Error(0)
 }
 
+func (m *mockMessageClient) SendPacked(tag string, entries protocol.EntryList) error {
+	args := m.Called(tag, entries)
+	return args.Error(0)
+}
+
+func (m *mockMessageClient) SendCompressed(tag string, entries protocol.EntryList) error {
+	args := m.Called(tag, entries)
+	return args.Error(0)
+}
+
+func (m *mockMessageClient) Send(e protocol.ChunkEncoder) error {
+	args := m.Called(e)
+	return args.Error(0)
+}
+
+// matchMessage matches a Message mode message with the given tag and record.
+func matchMessage(tag string, record map[string]any) any {
+	return mock.MatchedBy(
+		func(e protocol.ChunkEncoder) bool {
+			msg, ok := e.(*protocol.MessageExt)
+			return ok && msg.Tag == tag && reflect.DeepEqual(msg.Record, record)
+		},
+	)
+}
+
 func TestFluentLogger_Log(t *testing.T) {
 	type fields struct {
 		tag   string
@@ -39,53 +71,70 @@ func TestFluentLogger_Log(t *testing.T) {
 	tests := []struct {
 		name            string
 		fields          fields
-		msg             string
+		msg             Message
 		wantErr         require.ErrorAssertionFunc
 		otherAssertions func(t *testing.T, logger *FluentLogger)
 	}{
 		{
 			name: "log is successful w/ correct tag and stream",
-			msg:  "hello",
+			msg:  Message{Line: "hello"},
 			fields: func() fields {
 				tag := "tag"
 				src := "stream"
 				c := new(mockMessageClient)
-				rec := map[string]string{"log": "hello", "stream": src}
-				c.On("SendMessage", tag, rec).Return(nil)
+				rec := map[string]any{"log": "hello", "stream": src}
+				c.On("Send", matchMessage(tag, rec)).Return(nil)
 				return fields{tag: tag, src: src, c: c}
 			}(),
 			wantErr: require.NoError,
 			otherAssertions: func(t *testing.T, logger *FluentLogger) {
-				require.False(t, logger.connected)
+				require.False(t, logger.connected.Load())
 			},
 		},
 		{
 			name: "log is successful w/ correct tag, stream, and extra",
-			msg:  "hello",
+			msg:  Message{Line: "hello"},
 			fields: func() fields {
 				tag := "tag"
 				stream := "stream"
 				extra := map[string]string{"foo": "bar"}
 				c := new(mockMessageClient)
-				rec := map[string]string{"log": "hello", "stream": stream, "foo": "bar"}
-				c.On("SendMessage", tag, rec).Return(nil)
+				rec := map[string]any{"log": "hello", "stream": stream, "foo": "bar"}
+				c.On("Send", matchMessage(tag, rec)).Return(nil)
 				return fields{tag: tag, src: stream, extra: extra, c: c}
 			}(),
 			wantErr: require.NoError,
 			otherAssertions: func(t *testing.T, logger *FluentLogger) {
-				require.False(t, logger.connected)
+				require.False(t, logger.connected.Load())
 			},
 		},
+		{
+			name: "log is successful w/ parsed fields, stream, and extra",
+			msg: Message{
+				Line:   `{"msg":"hello","n":1,"stream":"overridden"}`,
+				Fields: map[string]any{"msg": "hello", "n": int64(1), "stream": "overridden"},
+			},
+			fields: func() fields {
+				tag := "tag"
+				stream := "stream"
+				extra := map[string]string{"foo": "bar"}
+				c := new(mockMessageClient)
+				rec := map[string]any{"msg": "hello", "n": int64(1), "stream": stream, "foo": "bar"}
+				c.On("Send", matchMessage(tag, rec)).Return(nil)
+				return fields{tag: tag, src: stream, extra: extra, c: c}
+			}(),
+			wantErr: require.NoError,
+		},
 		{
 			name: "log error",
 			fields: func() fields {
 				c := new(mockMessageClient)
-				c.On("SendMessage", mock.Anything, mock.Anything).Return(errors.New("error"))
+				c.On("Send", mock.Anything).Return(errors.New("error"))
 				return fields{c: c}
 			}(),
 			wantErr: require.Error,
 			otherAssertions: func(t *testing.T, logger *FluentLogger) {
-				require.False(t, logger.connected)
+				require.False(t, logger.connected.Load())
 			},
 		},
 	}
@@ -108,6 +157,224 @@ func TestFluentLogger_Log(t *testing.T) {
 	}
 }
 
+func TestFluentLogger_EventTime(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.UTC)
+	c := new(mockMessageClient)
+	c.On(
+		"Send",
+		mock.MatchedBy(
+			func(e protocol.ChunkEncoder) bool {
+				msg, ok := e.(*protocol.MessageExt)
+				return ok && msg.Timestamp.Equal(ts)
+			},
+		),
+	).Return(nil)
+	c.On(
+		"SendPacked",
+		"tag",
+		mock.MatchedBy(
+			func(entries protocol.EntryList) bool {
+				return len(entries) == 2 && entries[0].Timestamp.Equal(ts) && !entries[1].Timestamp.IsZero()
+			},
+		),
+	).Return(nil)
+	logger := &FluentLogger{tag: "tag", stream: "stream", c: c}
+	require.NoError(t, logger.Log(Message{Line: "hello", Time: ts}))
+	// Messages without a time are sent with the current time.
+	require.NoError(t, logger.LogBatch([]Message{{Line: "hello", Time: ts}, {Line: "world"}}))
+	c.AssertExpectations(t)
+}
+
+func TestFluentLogger_Log_GzipCompression(t *testing.T) {
+	c := new(mockMessageClient)
+	c.On(
+		"SendCompressed",
+		"tag",
+		mock.MatchedBy(
+			func(entries protocol.EntryList) bool {
+				return len(entries) == 1 &&
+					reflect.DeepEqual(entries[0].Record, map[string]any{"log": "hello", "stream": "stream"})
+			},
+		),
+	).Return(nil)
+	logger := &FluentLogger{tag: "tag", stream: "stream", compression: CompressionGzip, c: c}
+	require.NoError(t, logger.Log(Message{Line: "hello"}))
+	c.AssertExpectations(t)
+}
+
+func TestFluentLogger_LogBatch(t *testing.T) {
+	matchRecords := func(want ...map[string]any) any {
+		return mock.MatchedBy(
+			func(entries protocol.EntryList) bool {
+				if len(entries) != len(want) {
+					return false
+				}
+				for i, e := range entries {
+					if !reflect.DeepEqual(e.Record, want[i]) {
+						return false
+					}
+				}
+				return true
+			},
+		)
+	}
+	tests := []struct {
+		name        string
+		batchMode   BatchMode
+		compression Compression
+		setup       func(c *mockMessageClient)
+		wantErr     require.ErrorAssertionFunc
+	}{
+		{
+			name: "defaults to packed forward mode",
+			setup: func(c *mockMessageClient) {
+				c.On(
+					"SendPacked",
+					"tag",
+					matchRecords(
+						map[string]any{"log": "hello", "stream": "stream", "foo": "bar"},
+						map[string]any{"log": "world", "stream": "stream", "foo": "bar"},
+					),
+				).Return(nil)
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:      "forward mode",
+			batchMode: BatchModeForward,
+			setup: func(c *mockMessageClient) {
+				c.On(
+					"SendForward",
+					"tag",
+					matchRecords(
+						map[string]any{"log": "hello", "stream": "stream", "foo": "bar"},
+						map[string]any{"log": "world", "stream": "stream", "foo": "bar"},
+					),
+				).Return(nil)
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:        "gzip compression",
+			batchMode:   BatchModeForward,
+			compression: CompressionGzip,
+			setup: func(c *mockMessageClient) {
+				c.On(
+					"SendCompressed",
+					"tag",
+					matchRecords(
+						map[string]any{"log": "hello", "stream": "stream", "foo": "bar"},
+						map[string]any{"log": "world", "stream": "stream", "foo": "bar"},
+					),
+				).Return(nil)
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:      "log error",
+			batchMode: BatchModePacked,
+			setup: func(c *mockMessageClient) {
+				c.On("SendPacked", mock.Anything, mock.Anything).Return(errors.New("error"))
+			},
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c := new(mockMessageClient)
+				tt.setup(c)
+				logger := &FluentLogger{
+					tag:         "tag",
+					stream:      "stream",
+					extra:       map[string]string{"foo": "bar"},
+					batchMode:   tt.batchMode,
+					compression: tt.compression,
+					c:           c,
+				}
+				tt.wantErr(t, logger.LogBatch([]Message{{Line: "hello"}, {Line: "world"}}))
+				c.AssertExpectations(t)
+			},
+		)
+	}
+}
+
+func TestFluentLogger_RequireAck(t *testing.T) {
+	tests := []struct {
+		name    string
+		ack     bool
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "acknowledged",
+			ack:     true,
+			wantErr: require.NoError,
+		},
+		{
+			name:    "not acknowledged",
+			ack:     false,
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				addr := ackServer(t, tt.ack)
+				logger := NewFluentLogger(
+					"tcp",
+					addr,
+					"tag",
+					"stdout",
+					nil,
+					FluentLoggerOptions{RequireAck: true, AckTimeout: 100 * time.Millisecond},
+				)
+				require.NoError(t, logger.Connect())
+				defer func() { _ = logger.Disconnect() }()
+				tt.wantErr(t, logger.Log(Message{Line: "hello"}))
+				tt.wantErr(t, logger.LogBatch([]Message{{Line: "hello"}, {Line: "world"}}))
+			},
+		)
+	}
+}
+
+// ackServer starts a local TCP server which reads Forward protocol messages
+// and, if ack is true, acknowledges their chunks. It returns the server's
+// address.
+func ackServer(t *testing.T, ack bool) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				r := msgp.NewReader(conn)
+				for {
+					msg, err := r.ReadIntf()
+					if err != nil {
+						return
+					}
+					// The options are always the last element of the message.
+					fields := msg.([]any)
+					opts := fields[len(fields)-1].(map[string]any)
+					if !ack {
+						continue
+					}
+					resp := &protocol.AckMessage{Ack: opts["chunk"].(string)}
+					if err := msgp.Encode(conn, resp); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
 func TestFluentLogger_Connect(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -124,7 +391,7 @@ func TestFluentLogger_Connect(t *testing.T) {
 			}(),
 			wantErr: require.NoError,
 			otherAssertions: func(t *testing.T, logger *FluentLogger) {
-				require.True(t, logger.connected)
+				require.True(t, logger.connected.Load())
 			},
 		},
 		{
@@ -136,7 +403,7 @@ func TestFluentLogger_Connect(t *testing.T) {
 			}(),
 			wantErr: require.Error,
 			otherAssertions: func(t *testing.T, logger *FluentLogger) {
-				require.False(t, logger.connected)
+				require.False(t, logger.connected.Load())
 			},
 		},
 	}
@@ -171,7 +438,7 @@ func TestFluentLogger_Disconnect(t *testing.T) {
 			}(),
 			wantErr: require.NoError,
 			otherAssertions: func(t *testing.T, logger *FluentLogger) {
-				require.False(t, logger.connected)
+				require.False(t, logger.connected.Load())
 			},
 		},
 		{
@@ -183,7 +450,7 @@ func TestFluentLogger_Disconnect(t *testing.T) {
 			}(),
 			wantErr: require.Error,
 			otherAssertions: func(t *testing.T, logger *FluentLogger) {
-				require.False(t, logger.connected)
+				require.False(t, logger.connected.Load())
 			},
 		},
 		{
@@ -196,14 +463,15 @@ func TestFluentLogger_Disconnect(t *testing.T) {
 			connected: true,
 			wantErr:   require.NoError,
 			otherAssertions: func(t *testing.T, logger *FluentLogger) {
-				require.False(t, logger.connected)
+				require.False(t, logger.connected.Load())
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
-				logger := &FluentLogger{c: tt.c, connected: tt.connected}
+				logger := &FluentLogger{c: tt.c}
+				logger.connected.Store(tt.connected)
 				tt.wantErr(t, logger.Disconnect())
 				tt.c.AssertExpectations(t)
 				if tt.otherAssertions != nil {
@@ -234,7 +502,8 @@ func TestFluentLogger_IsConnected(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
-				logger := &FluentLogger{connected: tt.connected}
+				logger := &FluentLogger{}
+				logger.connected.Store(tt.connected)
 				require.Equal(t, tt.want, logger.IsConnected())
 			},
 		)
@@ -242,6 +511,88 @@ func TestFluentLogger_IsConnected(t *testing.T) {
 }
 
 func TestNewFluentLogger_LoggerIsNotConnected(t *testing.T) {
-	l := NewFluentLogger("", "", "", "", nil)
+	l := NewFluentLogger("", "", "", "", nil, FluentLoggerOptions{})
+	require.False(t, l.IsConnected())
+}
+
+func TestNewFluentLogger_ConnectTimeout(t *testing.T) {
+	l := NewFluentLogger("tcp", "localhost:24224", "", "", nil, FluentLoggerOptions{ConnectTimeout: 3 * time.Second})
+	factory := l.c.(*client.Client).ConnectionFactory.(*client.ConnFactory)
+	require.Equal(t, 3*time.Second, factory.Timeout)
+}
+
+func TestFluentLogger_Connect_TimesOut(t *testing.T) {
+	// The server never accepts connections, so the TLS handshake never
+	// completes (the kernel completes the TCP handshake on its behalf).
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = ln.Close() }()
+	timeout := 100 * time.Millisecond
+	l := NewFluentLogger("tls", ln.Addr().String(), "", "", nil, FluentLoggerOptions{ConnectTimeout: timeout})
+	start := time.Now()
+	require.Error(t, l.Connect())
+	require.Less(t, time.Since(start), 5*time.Second)
 	require.False(t, l.IsConnected())
 }
+
+// benchmarkLogger returns a connected FluentLogger that sends messages to a
+// local TCP server which discards everything it receives.
+func benchmarkLogger(b *testing.B, batchMode BatchMode) *FluentLogger {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(b, err)
+	b.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(io.Discard, conn) }()
+		}
+	}()
+	logger := NewFluentLogger(
+		"tcp",
+		l.Addr().String(),
+		"tag",
+		"stdout",
+		nil,
+		FluentLoggerOptions{BatchMode: batchMode},
+	)
+	require.NoError(b, logger.Connect())
+	b.Cleanup(func() { _ = logger.Disconnect() })
+	return logger
+}
+
+func BenchmarkFluentLogger_Log(b *testing.B) {
+	logger := benchmarkLogger(b, "")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := logger.Log(Message{Line: "this is a typical log line of typical length"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFluentLogger_LogBatch(b *testing.B) {
+	for _, mode := range []BatchMode{BatchModeForward, BatchModePacked} {
+		for _, size := range []int{10, 100, 1000} {
+			b.Run(
+				string(mode)+"/"+strconv.Itoa(size), func(b *testing.B) {
+					logger := benchmarkLogger(b, mode)
+					batch := make([]Message, size)
+					for i := range batch {
+						batch[i] = Message{Line: "this is a typical log line of typical length"}
+					}
+					b.ResetTimer()
+					// Each iteration sends size messages, so the results are
+					// comparable to BenchmarkFluentLogger_Log.
+					for i := 0; i < b.N; i += size {
+						if err := logger.LogBatch(batch); err != nil {
+							b.Fatal(err)
+						}
+					}
+				},
+			)
+		}
+	}
+}
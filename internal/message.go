@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Message is a single log message read by a Forwarder.
+type Message struct {
+	// Line is the raw log line, without the trailing newline.
+	Line string
+	// Fields holds the message's structured fields. If it is nil, the message
+	// is sent to Fluent as the raw line alone, under the "log" key. Otherwise,
+	// Fields is sent as-is.
+	Fields map[string]any
+	// Time is the message's event time - either the time parsed from the
+	// message's fields, or the time the line was read. If it is zero, the time
+	// the message is sent is used instead.
+	Time time.Time
+}
+
+// MarshalMsg appends the MessagePack encoding of the message to b.
+func (m Message) MarshalMsg(b []byte) ([]byte, error) {
+	b = msgp.AppendArrayHeader(b, 3)
+	b = msgp.AppendString(b, m.Line)
+	if m.Fields == nil {
+		b = msgp.AppendNil(b)
+	} else {
+		var err error
+		if b, err = msgp.AppendMapStrIntf(b, m.Fields); err != nil {
+			return nil, err
+		}
+	}
+	if m.Time.IsZero() {
+		return msgp.AppendNil(b), nil
+	}
+	return msgp.AppendTime(b, m.Time), nil
+}
+
+// UnmarshalMsg decodes a message encoded with Message.MarshalMsg from b, and
+// returns any remaining bytes.
+func (m *Message) UnmarshalMsg(b []byte) ([]byte, error) {
+	n, b, err := msgp.ReadArrayHeaderBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	if n != 3 {
+		return nil, fmt.Errorf("invalid message: expected 3 elements, got %d", n)
+	}
+	if m.Line, b, err = msgp.ReadStringBytes(b); err != nil {
+		return nil, err
+	}
+	if msgp.IsNil(b) {
+		m.Fields = nil
+		if b, err = msgp.ReadNilBytes(b); err != nil {
+			return nil, err
+		}
+	} else if m.Fields, b, err = msgp.ReadMapStrIntfBytes(b, nil); err != nil {
+		return nil, err
+	}
+	if msgp.IsNil(b) {
+		m.Time = time.Time{}
+		return msgp.ReadNilBytes(b)
+	}
+	m.Time, b, err = msgp.ReadTimeBytes(b)
+	return b, err
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MetricsHandler returns an http.Handler which serves the Forwarders' Stats as
+// Prometheus metrics, in the text exposition format, labelled by stream.
+func MetricsHandler(fwdrs []*Forwarder) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+			if err := WriteMetrics(w, fwdrs); err != nil {
+				slog.Debug("error writing metrics", "error", err)
+			}
+		},
+	)
+}
+
+// WriteMetrics writes the Forwarders' Stats to w as Prometheus metrics, in the
+// text exposition format.
+func WriteMetrics(w io.Writer, fwdrs []*Forwarder) error {
+	stats := make([]Stats, len(fwdrs))
+	for i, fwdr := range fwdrs {
+		stats[i] = fwdr.Stats()
+	}
+	bw := bufio.NewWriter(w)
+	// metric writes a metric with a value per stream.
+	metric := func(name, typ, help string, value func(Stats) string) {
+		_, _ = fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for i, fwdr := range fwdrs {
+			_, _ = fmt.Fprintf(bw, "%s{stream=%s} %s\n", name, labelValue(fwdr.Name()), value(stats[i]))
+		}
+	}
+	counter := func(name, help string, value func(Stats) uint64) {
+		metric(name, "counter", help, func(s Stats) string { return strconv.FormatUint(value(s), 10) })
+	}
+	gauge := func(name, help string, value func(Stats) int) {
+		metric(name, "gauge", help, func(s Stats) string { return strconv.Itoa(value(s)) })
+	}
+
+	counter(
+		"log2fluent_lines_read_total",
+		"Lines read from the stream.",
+		func(s Stats) uint64 { return s.LinesRead },
+	)
+	counter(
+		"log2fluent_bytes_read_total",
+		"Bytes read from the stream.",
+		func(s Stats) uint64 { return s.BytesRead },
+	)
+	counter(
+		"log2fluent_lines_sent_total",
+		"Messages sent to Fluent.",
+		func(s Stats) uint64 { return s.LinesSent },
+	)
+	counter(
+		"log2fluent_reconnects_total",
+		"Times the connection to Fluent was re-established.",
+		func(s Stats) uint64 { return s.Reconnects },
+	)
+
+	const dropped = "log2fluent_lines_dropped_total"
+	_, _ = fmt.Fprintf(bw, "# HELP %s Messages dropped, by reason.\n# TYPE %s counter\n", dropped, dropped)
+	for i, fwdr := range fwdrs {
+		for _, reason := range DropReasons {
+			_, _ = fmt.Fprintf(
+				bw,
+				"%s{stream=%s,reason=%s} %d\n",
+				dropped,
+				labelValue(fwdr.Name()),
+				labelValue(string(reason)),
+				stats[i].Dropped[reason],
+			)
+		}
+	}
+
+	const latency = "log2fluent_send_duration_seconds"
+	_, _ = fmt.Fprintf(
+		bw, "# HELP %s Time taken to send a message or batch to Fluent.\n# TYPE %s histogram\n", latency, latency,
+	)
+	for i, fwdr := range fwdrs {
+		h, stream := stats[i].SendLatency, labelValue(fwdr.Name())
+		for j, bound := range LatencyBuckets {
+			le := labelValue(strconv.FormatFloat(bound.Seconds(), 'g', -1, 64))
+			_, _ = fmt.Fprintf(bw, "%s_bucket{stream=%s,le=%s} %d\n", latency, stream, le, h.Counts[j])
+		}
+		_, _ = fmt.Fprintf(bw, "%s_bucket{stream=%s,le=\"+Inf\"} %d\n", latency, stream, h.Count)
+		_, _ = fmt.Fprintf(
+			bw, "%s_sum{stream=%s} %s\n", latency, stream, strconv.FormatFloat(h.Sum.Seconds(), 'g', -1, 64),
+		)
+		_, _ = fmt.Fprintf(bw, "%s_count{stream=%s} %d\n", latency, stream, h.Count)
+	}
+
+	gauge(
+		"log2fluent_queue_length",
+		"Messages currently buffered, waiting to be sent to Fluent.",
+		func(s Stats) int { return s.QueueLen },
+	)
+	gauge(
+		"log2fluent_queue_capacity",
+		"The size of the message buffer.",
+		func(s Stats) int { return s.QueueCap },
+	)
+	return bw.Flush()
+}
+
+// labelEscaper escapes label values as the text exposition format requires:
+// only backslashes, double quotes and newlines are escaped, unlike in Go
+// quoted strings.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue returns the quoted label value, escaped for the text exposition
+// format.
+func labelValue(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
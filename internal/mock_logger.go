@@ -64,7 +64,7 @@ func (_m *MockLogger) IsConnected() bool {
 }
 
 // Log provides a mock function with given fields: msg
-func (_m *MockLogger) Log(msg string) error {
+func (_m *MockLogger) Log(msg Message) error {
 	ret := _m.Called(msg)
 
 	if len(ret) == 0 {
@@ -72,7 +72,7 @@ func (_m *MockLogger) Log(msg string) error {
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string) error); ok {
+	if rf, ok := ret.Get(0).(func(Message) error); ok {
 		r0 = rf(msg)
 	} else {
 		r0 = ret.Error(0)
@@ -81,6 +81,24 @@ func (_m *MockLogger) Log(msg string) error {
 	return r0
 }
 
+// LogBatch provides a mock function with given fields: msgs
+func (_m *MockLogger) LogBatch(msgs []Message) error {
+	ret := _m.Called(msgs)
+
+	if len(ret) == 0 {
+		panic("no return value specified for LogBatch")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func([]Message) error); ok {
+		r0 = rf(msgs)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // NewMockLogger creates a new instance of MockLogger. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewMockLogger(t interface {
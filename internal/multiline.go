@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// The maximum number of lines in a single multiline record. Once a record
+// reaches this many lines, it is emitted even if it isn't complete, so that a
+// stream of continuation lines can't grow a record forever.
+const multilineMaxLines = 1000
+
+// multilinePresets are continuation patterns for common multiline formats,
+// i.e. lines matching these patterns belong to the preceding record.
+var multilinePresets = map[string]string{
+	// Stack trace lines are indented, except for causes.
+	"java": `^(\s|Caused by:)`,
+	// Tracebacks are indented, except for the header, the final exception
+	// line, and the lines between chained exceptions.
+	"python": `^(\s|$|Traceback \(most recent call last\):|During handling of the above exception|` +
+		`The above exception was the direct cause|[\w.]+(Error|Exception|Warning|Exit|Interrupt)\b)`,
+	// Panics and goroutine dumps are made up of goroutine headers, unindented
+	// function calls followed by indented file locations, and blank lines
+	// between goroutines.
+	"go": `^(\s|$|goroutine \d+ \[|\[signal |created by |[\w./*()%-]+\(.*\)$)`,
+}
+
+// Multiline aggregates consecutive lines that belong together, such as the
+// lines of a stack trace, into single records. Whether a line starts a new
+// record is determined either by a start-of-record pattern, or by a
+// continuation pattern (for presets).
+type Multiline struct {
+	start   *regexp.Regexp // Lines matching start begin a new record.
+	cont    *regexp.Regexp // Lines not matching cont begin a new record.
+	timeout time.Duration  // How long to wait for more lines before emitting.
+}
+
+// NewMultiline returns a Multiline which starts a new record at each line
+// matching the given regular expression. Lines which don't match are appended
+// to the current record. A record is emitted once the next record starts, or
+// once no new lines have been read for the given timeout.
+func NewMultiline(start string, timeout time.Duration) (*Multiline, error) {
+	re, err := regexp.Compile(start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multiline start regex: %w", err)
+	}
+	return &Multiline{start: re, timeout: timeout}, nil
+}
+
+// NewMultilinePreset returns a Multiline for one of the built-in presets:
+// java (exception stack traces), python (tracebacks), or go (panics and
+// goroutine dumps). Records are emitted as with NewMultiline.
+func NewMultilinePreset(preset string, timeout time.Duration) (*Multiline, error) {
+	expr, ok := multilinePresets[preset]
+	if !ok {
+		return nil, fmt.Errorf("invalid multiline preset %q", preset)
+	}
+	return &Multiline{cont: regexp.MustCompile(expr), timeout: timeout}, nil
+}
+
+// isStart returns true if the line begins a new record.
+func (m *Multiline) isStart(line string) bool {
+	if m.cont != nil {
+		return !m.cont.MatchString(line)
+	}
+	return m.start.MatchString(line)
+}
+
+// aggregate reads lines from the channel until it is closed, and passes each
+// aggregated record, with its lines joined by newlines, to emit. A record has
+// the time its first line was read.
+func (m *Multiline) aggregate(lines <-chan rawLine, emit func(rawLine)) {
+	var (
+		record []rawLine
+		timer  = time.NewTimer(m.timeout)
+	)
+	timer.Stop()
+	defer timer.Stop()
+	flush := func() {
+		if len(record) > 0 {
+			emit(join(record))
+			record = nil
+		}
+	}
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				flush()
+				return
+			}
+			if len(record) > 0 && m.isStart(line.text) {
+				flush()
+			}
+			record = append(record, line)
+			if len(record) >= multilineMaxLines {
+				flush()
+			}
+			// Drain a tick from before this line, so that it doesn't flush
+			// the record early.
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(m.timeout)
+		case <-timer.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiline_aggregate(t *testing.T) {
+	tests := []struct {
+		name  string
+		ml    func(t *testing.T) *Multiline
+		lines []string
+		want  []string
+	}{
+		{
+			name: "start regex",
+			ml: func(t *testing.T) *Multiline {
+				m, err := NewMultiline(`^\d{4}-`, time.Minute)
+				require.NoError(t, err)
+				return m
+			},
+			lines: []string{
+				"continuation before any start",
+				"2024-01-01 first",
+				"  more",
+				"2024-01-02 second",
+				"2024-01-03 third",
+				"  more",
+				"  and more",
+			},
+			want: []string{
+				"continuation before any start",
+				"2024-01-01 first\n  more",
+				"2024-01-02 second",
+				"2024-01-03 third\n  more\n  and more",
+			},
+		},
+		{
+			name: "java",
+			ml: func(t *testing.T) *Multiline {
+				m, err := NewMultilinePreset("java", time.Minute)
+				require.NoError(t, err)
+				return m
+			},
+			lines: []string{
+				"Exception in thread \"main\" java.lang.IllegalStateException: outer",
+				"\tat com.example.Main.run(Main.java:10)",
+				"\tat com.example.Main.main(Main.java:5)",
+				"Caused by: java.lang.NullPointerException: inner",
+				"\tat com.example.Main.load(Main.java:20)",
+				"\t... 2 more",
+				"next log line",
+			},
+			want: []string{
+				"Exception in thread \"main\" java.lang.IllegalStateException: outer\n" +
+					"\tat com.example.Main.run(Main.java:10)\n" +
+					"\tat com.example.Main.main(Main.java:5)\n" +
+					"Caused by: java.lang.NullPointerException: inner\n" +
+					"\tat com.example.Main.load(Main.java:20)\n" +
+					"\t... 2 more",
+				"next log line",
+			},
+		},
+		{
+			name: "python",
+			ml: func(t *testing.T) *Multiline {
+				m, err := NewMultilinePreset("python", time.Minute)
+				require.NoError(t, err)
+				return m
+			},
+			lines: []string{
+				"ERROR something went wrong",
+				"Traceback (most recent call last):",
+				"  File \"main.py\", line 3, in <module>",
+				"    main()",
+				"KeyError: 'x'",
+				"",
+				"During handling of the above exception, another exception occurred:",
+				"",
+				"Traceback (most recent call last):",
+				"  File \"main.py\", line 5, in <module>",
+				"ValueError: bad",
+				"next log line",
+			},
+			want: []string{
+				"ERROR something went wrong\n" +
+					"Traceback (most recent call last):\n" +
+					"  File \"main.py\", line 3, in <module>\n" +
+					"    main()\n" +
+					"KeyError: 'x'\n" +
+					"\n" +
+					"During handling of the above exception, another exception occurred:\n" +
+					"\n" +
+					"Traceback (most recent call last):\n" +
+					"  File \"main.py\", line 5, in <module>\n" +
+					"ValueError: bad",
+				"next log line",
+			},
+		},
+		{
+			name: "go",
+			ml: func(t *testing.T) *Multiline {
+				m, err := NewMultilinePreset("go", time.Minute)
+				require.NoError(t, err)
+				return m
+			},
+			lines: []string{
+				"panic: runtime error: index out of range [5] with length 3",
+				"",
+				"goroutine 1 [running]:",
+				"main.main()",
+				"\t/tmp/main.go:8 +0x1d",
+				"",
+				"goroutine 6 [chan receive]:",
+				"net/http.(*conn).serve(0xc000118000, {0x6f1e38, 0xc00010a000})",
+				"\t/usr/local/go/src/net/http/server.go:2009 +0x5f4",
+				"created by net/http.(*Server).Serve in goroutine 1",
+				"\t/usr/local/go/src/net/http/server.go:3086 +0x5cb",
+				"next log line",
+			},
+			want: []string{
+				"panic: runtime error: index out of range [5] with length 3\n" +
+					"\n" +
+					"goroutine 1 [running]:\n" +
+					"main.main()\n" +
+					"\t/tmp/main.go:8 +0x1d\n" +
+					"\n" +
+					"goroutine 6 [chan receive]:\n" +
+					"net/http.(*conn).serve(0xc000118000, {0x6f1e38, 0xc00010a000})\n" +
+					"\t/usr/local/go/src/net/http/server.go:2009 +0x5f4\n" +
+					"created by net/http.(*Server).Serve in goroutine 1\n" +
+					"\t/usr/local/go/src/net/http/server.go:3086 +0x5cb",
+				"next log line",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				lines := make(chan rawLine, len(tt.lines))
+				for _, line := range tt.lines {
+					lines <- rawLine{text: line}
+				}
+				close(lines)
+				var got []string
+				tt.ml(t).aggregate(lines, func(record rawLine) { got = append(got, record.text) })
+				require.Equal(t, tt.want, got)
+			},
+		)
+	}
+}
+
+func TestMultiline_aggregate_MaxLines(t *testing.T) {
+	m, err := NewMultiline(`^start`, time.Minute)
+	require.NoError(t, err)
+	lines := make(chan rawLine, multilineMaxLines+1)
+	lines <- rawLine{text: "start"}
+	for i := 0; i < multilineMaxLines; i++ {
+		lines <- rawLine{text: "more"}
+	}
+	close(lines)
+	var got []string
+	m.aggregate(lines, func(record rawLine) { got = append(got, record.text) })
+	require.Len(t, got, 2)
+	require.Equal(t, multilineMaxLines, strings.Count(got[0], "\n")+1)
+	require.Equal(t, "more", got[1])
+}
+
+func TestMultiline_aggregate_FlushesAfterTimeout(t *testing.T) {
+	m, err := NewMultiline(`^start`, 10*time.Millisecond)
+	require.NoError(t, err)
+	lines := make(chan rawLine)
+	records := make(chan string, 2)
+	go m.aggregate(lines, func(record rawLine) { records <- record.text })
+	lines <- rawLine{text: "start"}
+	lines <- rawLine{text: "more"}
+	// The record is emitted even though the next record hasn't started yet.
+	select {
+	case record := <-records:
+		require.Equal(t, "start\nmore", record)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for record")
+	}
+	close(lines)
+}
+
+func TestMultiline_aggregate_TimeoutDuringEmit(t *testing.T) {
+	m, err := NewMultiline(`^start`, 100*time.Millisecond)
+	require.NoError(t, err)
+	lines := make(chan rawLine)
+	var got []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.aggregate(
+			lines, func(record rawLine) {
+				if len(got) == 0 {
+					// The timeout expires while the first record is emitted.
+					time.Sleep(200 * time.Millisecond)
+				}
+				got = append(got, record.text)
+			},
+		)
+	}()
+	lines <- rawLine{text: "start1"}
+	lines <- rawLine{text: "start2"}
+	time.Sleep(250 * time.Millisecond)
+	// This arrives within the timeout of the second record starting, so it
+	// belongs to it.
+	lines <- rawLine{text: "more"}
+	close(lines)
+	<-done
+	require.Equal(t, []string{"start1", "start2\nmore"}, got)
+}
+
+func TestNewMultiline_InvalidRegex(t *testing.T) {
+	_, err := NewMultiline(`(`, time.Second)
+	require.Error(t, err)
+}
+
+func TestNewMultilinePreset_Invalid(t *testing.T) {
+	_, err := NewMultilinePreset("cobol", time.Second)
+	require.Error(t, err)
+}
+
+func TestMultiline_aggregate_JoinsTimesAndAnnotations(t *testing.T) {
+	m, err := NewMultiline(`^start`, time.Minute)
+	require.NoError(t, err)
+	first, second := time.Unix(1, 0), time.Unix(2, 0)
+	lines := make(chan rawLine, 2)
+	lines <- rawLine{text: "start", time: first}
+	lines <- rawLine{text: "more", time: second, attrs: map[string]any{"truncated": true}}
+	close(lines)
+	var got []rawLine
+	m.aggregate(lines, func(record rawLine) { got = append(got, record) })
+	want := []rawLine{{text: "start\nmore", time: first, attrs: map[string]any{"truncated": true}}}
+	require.Equal(t, want, got)
+}
@@ -0,0 +1,249 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long a MultiLogger waits before trying to connect to a destination again
+// after it failed.
+const destinationRetryInterval = 5 * time.Second
+
+// DestinationMode is how a MultiLogger distributes messages between its
+// destinations.
+type DestinationMode string
+
+const (
+	// ModeFailover sends messages to the first destination (in the order they
+	// were given) which is available. If it fails, the next one is used, and so
+	// on. Once a preferred destination recovers, messages are sent to it again.
+	// This is the default.
+	ModeFailover DestinationMode = "failover"
+	// ModeBroadcast sends every message to every available destination. A
+	// destination which is unavailable misses the messages sent while it is
+	// down.
+	ModeBroadcast DestinationMode = "broadcast"
+	// ModeRoundRobin sends each message (or batch) to the next available
+	// destination in turn.
+	ModeRoundRobin DestinationMode = "round-robin"
+)
+
+// MarshalText implements encoding.TextMarshaler.
+func (m DestinationMode) MarshalText() ([]byte, error) {
+	return []byte(m), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It returns an error if the
+// text is not a valid DestinationMode.
+func (m *DestinationMode) UnmarshalText(text []byte) error {
+	switch mode := DestinationMode(text); mode {
+	case ModeFailover, ModeBroadcast, ModeRoundRobin:
+		*m = mode
+		return nil
+	default:
+		return fmt.Errorf("invalid destination mode %q", text)
+	}
+}
+
+// MultiLogger is a Logger which sends messages to several destinations, each
+// with its own Logger, according to its DestinationMode. It manages the
+// connections of its destinations itself: a destination which fails is
+// disconnected, and is then reconnected in the background, so that sending to
+// the others is never held up by it. A MultiLogger only fails if none of its
+// destinations are available. It must be closed once it is no longer used.
+type MultiLogger struct {
+	mode          DestinationMode
+	dests         []*destination
+	next          int           // The next destination to use, in round-robin mode.
+	retryInterval time.Duration // How often failed destinations are retried.
+	closed        chan struct{} // Closed once the MultiLogger is.
+	mu            sync.Mutex    // Serializes closing with reconnections.
+}
+
+// destination is one of a MultiLogger's destinations.
+type destination struct {
+	logger Logger
+	// down is true while the destination has failed. Its Logger is then only
+	// used by the goroutine reconnecting it.
+	down atomic.Bool
+}
+
+// NewMultiLogger returns a MultiLogger for the given destination Loggers. In
+// failover mode, the Loggers are in order of preference.
+func NewMultiLogger(loggers []Logger, mode DestinationMode) *MultiLogger {
+	dests := make([]*destination, len(loggers))
+	for i, logger := range loggers {
+		dests[i] = &destination{logger: logger}
+	}
+	return &MultiLogger{
+		mode:          mode,
+		dests:         dests,
+		retryInterval: destinationRetryInterval,
+		closed:        make(chan struct{}),
+	}
+}
+
+// Log sends the message to the MultiLogger's destinations, according to its
+// mode.
+func (m *MultiLogger) Log(msg Message) error {
+	return m.send(func(l Logger) error { return l.Log(msg) })
+}
+
+// LogBatch sends the messages to the MultiLogger's destinations, according to
+// its mode.
+func (m *MultiLogger) LogBatch(msgs []Message) error {
+	return m.send(func(l Logger) error { return l.LogBatch(msgs) })
+}
+
+// Connect connects to the destinations which haven't failed - all of them, or
+// just the first one available in failover mode. It returns an error if no
+// destination could be connected to.
+func (m *MultiLogger) Connect() error {
+	var errs []error
+	connected := false
+	for i := range m.dests {
+		if err := m.connect(i); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		connected = true
+		if m.mode == ModeFailover {
+			break
+		}
+	}
+	if !connected {
+		return fmt.Errorf("no destination available: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Disconnect disconnects from all the destinations which haven't failed.
+// Failed destinations are already disconnected.
+func (m *MultiLogger) Disconnect() error {
+	var errs []error
+	for _, d := range m.dests {
+		if !d.down.Load() {
+			errs = append(errs, d.logger.Disconnect())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// IsConnected returns true if any of the destinations are connected.
+func (m *MultiLogger) IsConnected() bool {
+	for _, d := range m.dests {
+		if !d.down.Load() && d.logger.IsConnected() {
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops reconnecting to failed destinations, and disconnects from the
+// others.
+func (m *MultiLogger) Close() error {
+	m.mu.Lock()
+	select {
+	case <-m.closed:
+	default:
+		close(m.closed)
+	}
+	m.mu.Unlock()
+	return m.Disconnect()
+}
+
+// send sends with the given function to the destinations, according to the
+// MultiLogger's mode. It returns an error only if no destination succeeded.
+func (m *MultiLogger) send(fn func(Logger) error) error {
+	n := len(m.dests)
+	start := 0
+	if m.mode == ModeRoundRobin {
+		start = m.next
+	}
+	var errs []error
+	sent := false
+	for j := 0; j < n; j++ {
+		i := (start + j) % n
+		if err := m.connect(i); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := fn(m.dests[i].logger); err != nil {
+			slog.Debug("error sending to destination", "destination", i, "error", err)
+			m.fail(i)
+			errs = append(errs, err)
+			continue
+		}
+		sent = true
+		if m.mode != ModeBroadcast {
+			m.next = (i + 1) % n
+			break
+		}
+	}
+	if !sent {
+		return fmt.Errorf("error sending to all destinations: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// connect connects to the i-th destination, if it isn't already connected. It
+// returns an error without trying if the destination has failed, and is being
+// reconnected in the background.
+func (m *MultiLogger) connect(i int) error {
+	d := m.dests[i]
+	if d.down.Load() {
+		return fmt.Errorf("destination %d is unavailable", i)
+	}
+	if d.logger.IsConnected() {
+		return nil
+	}
+	if err := d.logger.Connect(); err != nil {
+		m.fail(i)
+		return err
+	}
+	return nil
+}
+
+// fail disconnects from the i-th destination, and reconnects to it in the
+// background.
+func (m *MultiLogger) fail(i int) {
+	d := m.dests[i]
+	_ = d.logger.Disconnect()
+	d.down.Store(true)
+	go m.reconnect(i)
+}
+
+// reconnect tries to connect to the failed i-th destination every retry
+// interval, until it succeeds or the MultiLogger is closed. The destination is
+// available again once it is connected.
+func (m *MultiLogger) reconnect(i int) {
+	d := m.dests[i]
+	ticker := time.NewTicker(m.retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.closed:
+			return
+		case <-ticker.C:
+		}
+		err := d.logger.Connect()
+		if err == nil {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			select {
+			case <-m.closed:
+				// Don't leave a connection behind once closed.
+				_ = d.logger.Disconnect()
+			default:
+				d.down.Store(false)
+			}
+			return
+		}
+		slog.Debug("error reconnecting to destination", "destination", i, "error", err)
+		_ = d.logger.Disconnect()
+	}
+}
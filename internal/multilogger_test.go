@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLogger is a Logger which records the lines it logs. It is safe for
+// concurrent use, since MultiLoggers reconnect failed destinations in the
+// background.
+type fakeLogger struct {
+	mu         sync.Mutex
+	connected  bool
+	connectErr error
+	logErr     error
+	lines      []string
+	connects   int // The number of successful connections.
+	// connecting, if not nil, is received from before each connection.
+	connecting chan struct{}
+}
+
+func (l *fakeLogger) Log(msg Message) error {
+	return l.LogBatch([]Message{msg})
+}
+
+func (l *fakeLogger) LogBatch(msgs []Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.connected {
+		return errors.New("not connected")
+	}
+	if l.logErr != nil {
+		return l.logErr
+	}
+	l.lines = append(l.lines, lines(msgs)...)
+	return nil
+}
+
+func (l *fakeLogger) Connect() error {
+	if l.connecting != nil {
+		<-l.connecting
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.connectErr != nil {
+		return l.connectErr
+	}
+	l.connected = true
+	l.connects++
+	return nil
+}
+
+func (l *fakeLogger) Disconnect() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.connected = false
+	return nil
+}
+
+func (l *fakeLogger) IsConnected() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.connected
+}
+
+// set sets the errors returned by the Logger.
+func (l *fakeLogger) set(connectErr, logErr error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.connectErr, l.logErr = connectErr, logErr
+}
+
+// logged returns the lines logged so far.
+func (l *fakeLogger) logged() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.lines)
+}
+
+func TestMultiLogger_Failover(t *testing.T) {
+	primary, standby := &fakeLogger{}, &fakeLogger{}
+	m := NewMultiLogger([]Logger{primary, standby}, ModeFailover)
+	m.retryInterval = 10 * time.Millisecond
+	defer func() { _ = m.Close() }()
+	require.NoError(t, m.Connect())
+	require.True(t, primary.IsConnected())
+	require.False(t, standby.IsConnected())
+	require.NoError(t, m.Log(Message{Line: "1"}))
+
+	// The primary goes down, so we fail over to the standby.
+	primary.set(errors.New("connection refused"), errors.New("connection reset"))
+	require.NoError(t, m.Log(Message{Line: "2"}))
+	require.False(t, primary.IsConnected())
+	require.NoError(t, m.LogBatch([]Message{{Line: "3"}, {Line: "4"}}))
+
+	// The primary recovers, so we fail back once it is reconnected.
+	primary.set(nil, nil)
+	require.Eventually(t, func() bool { return !m.dests[0].down.Load() }, 5*time.Second, time.Millisecond)
+	require.NoError(t, m.Log(Message{Line: "5"}))
+
+	require.Equal(t, []string{"1", "5"}, primary.logged())
+	require.Equal(t, []string{"2", "3", "4"}, standby.logged())
+}
+
+func TestMultiLogger_Failover_DoesNotWaitForFailedDestination(t *testing.T) {
+	primary, standby := &fakeLogger{}, &fakeLogger{}
+	m := NewMultiLogger([]Logger{primary, standby}, ModeFailover)
+	m.retryInterval = time.Millisecond
+	require.NoError(t, m.Connect())
+	primary.set(errors.New("connection timed out"), errors.New("connection reset"))
+	// Reconnecting to the primary hangs, e.g. because its packets are dropped.
+	primary.connecting = make(chan struct{})
+	require.NoError(t, m.Log(Message{Line: "1"}))
+	time.Sleep(10 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, line := range []string{"2", "3"} {
+			require.NoError(t, m.Log(Message{Line: line}))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sending was held up by the failed destination")
+	}
+	require.Equal(t, []string{"1", "2", "3"}, standby.logged())
+	// Closing stops reconnecting, without leaving a connection behind even if
+	// the connection is established afterwards.
+	require.NoError(t, m.Close())
+	primary.set(nil, nil)
+	close(primary.connecting)
+	require.Eventually(
+		t,
+		func() bool {
+			primary.mu.Lock()
+			defer primary.mu.Unlock()
+			return primary.connects == 2 && !primary.connected
+		},
+		5*time.Second,
+		time.Millisecond,
+	)
+}
+
+func TestMultiLogger_Broadcast(t *testing.T) {
+	a, b := &fakeLogger{}, &fakeLogger{}
+	m := NewMultiLogger([]Logger{a, b}, ModeBroadcast)
+	defer func() { _ = m.Close() }()
+	require.NoError(t, m.Connect())
+	require.NoError(t, m.Log(Message{Line: "1"}))
+	// A destination which fails misses messages, but the others still get them.
+	b.set(nil, errors.New("connection reset"))
+	require.NoError(t, m.Log(Message{Line: "2"}))
+	require.Equal(t, []string{"1", "2"}, a.logged())
+	require.Equal(t, []string{"1"}, b.logged())
+	// Sending fails if every destination fails.
+	a.set(nil, errors.New("connection reset"))
+	require.Error(t, m.Log(Message{Line: "3"}))
+	require.False(t, m.IsConnected())
+	// Failed destinations aren't retried straight away.
+	a.set(nil, nil)
+	b.set(nil, nil)
+	require.Error(t, m.Connect())
+}
+
+func TestMultiLogger_RoundRobin(t *testing.T) {
+	a, b, c := &fakeLogger{}, &fakeLogger{}, &fakeLogger{connectErr: errors.New("connection refused")}
+	m := NewMultiLogger([]Logger{a, b, c}, ModeRoundRobin)
+	defer func() { _ = m.Close() }()
+	require.NoError(t, m.Connect())
+	for _, line := range []string{"1", "2", "3", "4"} {
+		require.NoError(t, m.Log(Message{Line: line}))
+	}
+	// The unavailable destination is skipped.
+	require.Equal(t, []string{"1", "3"}, a.logged())
+	require.Equal(t, []string{"2", "4"}, b.logged())
+	require.Empty(t, c.logged())
+}
+
+func TestMultiLogger_Connect_NoDestinationAvailable(t *testing.T) {
+	a := &fakeLogger{connectErr: errors.New("connection refused")}
+	b := &fakeLogger{connectErr: errors.New("connection refused")}
+	m := NewMultiLogger([]Logger{a, b}, ModeFailover)
+	defer func() { _ = m.Close() }()
+	require.Error(t, m.Connect())
+	require.False(t, m.IsConnected())
+}
+
+func TestDestinationMode_UnmarshalText(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    DestinationMode
+		wantErr require.ErrorAssertionFunc
+	}{
+		{text: "failover", want: ModeFailover, wantErr: require.NoError},
+		{text: "broadcast", want: ModeBroadcast, wantErr: require.NoError},
+		{text: "round-robin", want: ModeRoundRobin, wantErr: require.NoError},
+		{text: "invalid", wantErr: require.Error},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.text, func(t *testing.T) {
+				var m DestinationMode
+				tt.wantErr(t, m.UnmarshalText([]byte(tt.text)))
+				require.Equal(t, tt.want, m)
+			},
+		)
+	}
+}
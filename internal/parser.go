@@ -0,0 +1,286 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Format is the format of the log lines read by a Forwarder, which determines
+// how they are parsed into structured fields.
+type Format string
+
+const (
+	// FormatPlain doesn't parse lines at all - each line is sent as-is under
+	// the "log" key. This is the default.
+	FormatPlain Format = "plain"
+	// FormatJSON parses each line as a JSON object.
+	FormatJSON Format = "json"
+	// FormatLogfmt parses each line as logfmt, i.e. space separated key=value
+	// pairs.
+	FormatLogfmt Format = "logfmt"
+)
+
+// MarshalText implements encoding.TextMarshaler.
+func (f Format) MarshalText() ([]byte, error) {
+	return []byte(f), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It returns an error if the
+// text is not a valid Format.
+func (f *Format) UnmarshalText(text []byte) error {
+	switch format := Format(text); format {
+	case FormatPlain, FormatJSON, FormatLogfmt:
+		*f = format
+		return nil
+	default:
+		return fmt.Errorf("invalid format %q", text)
+	}
+}
+
+// Parser returns the Parser for the format, or nil if lines of this format
+// aren't parsed.
+func (f Format) Parser() Parser {
+	switch f {
+	case FormatJSON:
+		return JSONParser{}
+	case FormatLogfmt:
+		return LogfmtParser{}
+	default:
+		return nil
+	}
+}
+
+// Parser parses log lines into structured fields.
+type Parser interface {
+	// Parse returns the fields parsed from the line, or an error if the line
+	// can't be parsed.
+	Parse(line string) (map[string]any, error)
+}
+
+// JSONParser is a Parser for lines which are JSON objects. Integers are parsed
+// as int64 rather than float64, so they don't lose precision.
+type JSONParser struct{}
+
+func (JSONParser) Parse(line string) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(line)))
+	dec.UseNumber()
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("error parsing JSON: %w", err)
+	}
+	if fields == nil {
+		return nil, errors.New("error parsing JSON: not an object")
+	}
+	if dec.More() {
+		return nil, errors.New("error parsing JSON: trailing data")
+	}
+	return convertNumbers(fields).(map[string]any), nil
+}
+
+// convertNumbers recursively replaces json.Number values with int64 or
+// float64 values.
+func convertNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, e := range v {
+			v[k] = convertNumbers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = convertNumbers(e)
+		}
+	}
+	return v
+}
+
+// LogfmtParser is a Parser for logfmt lines, e.g.
+//
+//	level=info msg="hello \"world\"" user=42 debug
+//
+// Values may be double-quoted, in which case they may contain spaces and Go
+// style escape sequences. A key without a value (debug, above) is given the
+// value true. All other values are strings. A line must contain at least one
+// key=value pair to be considered logfmt.
+type LogfmtParser struct{}
+
+func (LogfmtParser) Parse(line string) (map[string]any, error) {
+	fields := make(map[string]any)
+	pairs := 0
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		// Key
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '\t' && line[i] != '"' {
+			i++
+		}
+		key := line[start:i]
+		if key == "" {
+			return nil, fmt.Errorf("error parsing logfmt: expected key at position %d", start)
+		}
+		if i == len(line) || line[i] != '=' {
+			// A bare key.
+			if i < len(line) && line[i] == '"' {
+				return nil, fmt.Errorf("error parsing logfmt: unexpected quote at position %d", i)
+			}
+			fields[key] = true
+			continue
+		}
+		// Value
+		i++
+		pairs++
+		if i < len(line) && line[i] == '"' {
+			end, err := quotedEnd(line, i)
+			if err != nil {
+				return nil, err
+			}
+			value, err := strconv.Unquote(line[i:end])
+			if err != nil {
+				return nil, fmt.Errorf("error parsing logfmt: invalid quoted value at position %d: %w", i, err)
+			}
+			if end < len(line) && line[end] != ' ' && line[end] != '\t' {
+				return nil, fmt.Errorf("error parsing logfmt: unexpected character at position %d", end)
+			}
+			fields[key] = value
+			i = end
+			continue
+		}
+		start = i
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		value := line[start:i]
+		if strings.ContainsRune(value, '"') {
+			return nil, fmt.Errorf("error parsing logfmt: unexpected quote at position %d", start)
+		}
+		fields[key] = value
+	}
+	if pairs == 0 {
+		return nil, errors.New("error parsing logfmt: no key=value pairs")
+	}
+	return fields, nil
+}
+
+// quotedEnd returns the position just after the closing quote of the quoted
+// string starting at position start of s.
+func quotedEnd(s string, start int) (int, error) {
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			// Skip the escaped character.
+			i++
+		case '"':
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("error parsing logfmt: unterminated quoted value at position %d", start)
+}
+
+// FieldType is the type a parsed field's value is converted to.
+type FieldType string
+
+// The supported field types.
+const (
+	FieldTypeString FieldType = "string"
+	FieldTypeInt    FieldType = "int"
+	FieldTypeFloat  FieldType = "float"
+	FieldTypeBool   FieldType = "bool"
+)
+
+// ParseFieldType returns the FieldType with the given name, or an error if
+// there is no such FieldType.
+func ParseFieldType(s string) (FieldType, error) {
+	switch t := FieldType(s); t {
+	case FieldTypeString, FieldTypeInt, FieldTypeFloat, FieldTypeBool:
+		return t, nil
+	default:
+		return "", fmt.Errorf("invalid field type %q", s)
+	}
+}
+
+// convert returns the value converted to the type, or an error if it can't be
+// converted.
+func (t FieldType) convert(value string) (any, error) {
+	switch t {
+	case FieldTypeInt:
+		return strconv.ParseInt(value, 10, 64)
+	case FieldTypeFloat:
+		return strconv.ParseFloat(value, 64)
+	case FieldTypeBool:
+		return strconv.ParseBool(value)
+	default:
+		return value, nil
+	}
+}
+
+// RegexParser is a Parser which matches lines against a regular expression
+// with named capture groups. The value of each group that participates in the
+// match becomes a field, keyed by the group's name. Lines which don't match
+// can't be parsed.
+type RegexParser struct {
+	re    *regexp.Regexp
+	types map[string]FieldType
+}
+
+// NewRegexParser returns a RegexParser for the given regular expression, which
+// must have at least one named capture group. By default, fields are strings;
+// types maps group names to the type their values should be converted to
+// instead. If a value can't be converted, it is left as a string. An error is
+// returned if the expression is invalid, or if types refers to a group which
+// doesn't exist.
+func NewRegexParser(expr string, types map[string]FieldType) (*RegexParser, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parser regex: %w", err)
+	}
+	names := make(map[string]bool)
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			names[name] = true
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.New("invalid parser regex: no named capture groups")
+	}
+	for name := range types {
+		if !names[name] {
+			return nil, fmt.Errorf("invalid field type: no capture group named %q", name)
+		}
+	}
+	return &RegexParser{re: re, types: types}, nil
+}
+
+func (p *RegexParser) Parse(line string) (map[string]any, error) {
+	match := p.re.FindStringSubmatchIndex(line)
+	if match == nil {
+		return nil, errors.New("line doesn't match parser regex")
+	}
+	fields := make(map[string]any)
+	for i, name := range p.re.SubexpNames() {
+		start, end := match[2*i], match[2*i+1]
+		if name == "" || start < 0 {
+			continue
+		}
+		value := line[start:end]
+		fields[name] = value
+		if t, ok := p.types[name]; ok {
+			if v, err := t.convert(value); err == nil {
+				fields[name] = v
+			}
+		}
+	}
+	return fields, nil
+}
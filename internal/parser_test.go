@@ -0,0 +1,263 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONParser_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    map[string]any
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name: "object",
+			line: `{"level":"info","msg":"hello","ok":true,"n":42,"f":1.5,"nil":null}`,
+			want: map[string]any{
+				"level": "info",
+				"msg":   "hello",
+				"ok":    true,
+				"n":     int64(42),
+				"f":     1.5,
+				"nil":   nil,
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "nested",
+			line: `{"user":{"id":9007199254740993,"tags":["a",1]}}`,
+			want: map[string]any{
+				"user": map[string]any{
+					"id":   int64(9007199254740993),
+					"tags": []any{"a", int64(1)},
+				},
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "surrounding whitespace",
+			line:    ` {"msg":"hello"} `,
+			want:    map[string]any{"msg": "hello"},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "not JSON",
+			line:    "hello world",
+			wantErr: require.Error,
+		},
+		{
+			name:    "not an object",
+			line:    `["hello"]`,
+			wantErr: require.Error,
+		},
+		{
+			name:    "null",
+			line:    `null`,
+			wantErr: require.Error,
+		},
+		{
+			name:    "trailing data",
+			line:    `{"msg":"hello"} world`,
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := JSONParser{}.Parse(tt.line)
+				tt.wantErr(t, err)
+				require.Equal(t, tt.want, got)
+			},
+		)
+	}
+}
+
+func TestLogfmtParser_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    map[string]any
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "simple",
+			line:    "level=info user=42",
+			want:    map[string]any{"level": "info", "user": "42"},
+			wantErr: require.NoError,
+		},
+		{
+			name: "quoted values with escapes",
+			line: `level=info msg="hello \"world\"\n" path="C:\\tmp" empty=""`,
+			want: map[string]any{
+				"level": "info",
+				"msg":   "hello \"world\"\n",
+				"path":  `C:\tmp`,
+				"empty": "",
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "bare keys, empty values and extra whitespace",
+			line:    "  debug   level=  a=b\tverbose ",
+			want:    map[string]any{"debug": true, "level": "", "a": "b", "verbose": true},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "value containing equals sign",
+			line:    "query=a=b",
+			want:    map[string]any{"query": "a=b"},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "no key=value pairs",
+			line:    "hello world",
+			wantErr: require.Error,
+		},
+		{
+			name:    "empty",
+			line:    "",
+			wantErr: require.Error,
+		},
+		{
+			name:    "missing key",
+			line:    "level=info =foo",
+			wantErr: require.Error,
+		},
+		{
+			name:    "unterminated quote",
+			line:    `msg="hello`,
+			wantErr: require.Error,
+		},
+		{
+			name:    "text after closing quote",
+			line:    `msg="hello"world`,
+			wantErr: require.Error,
+		},
+		{
+			name:    "quote in unquoted value",
+			line:    `msg=hel"lo`,
+			wantErr: require.Error,
+		},
+		{
+			name:    "invalid escape",
+			line:    `msg="\q"`,
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := LogfmtParser{}.Parse(tt.line)
+				tt.wantErr(t, err)
+				require.Equal(t, tt.want, got)
+			},
+		)
+	}
+}
+
+func TestRegexParser_Parse(t *testing.T) {
+	const expr = `^(?P<ip>\S+) (?P<method>[A-Z]+) (?P<path>\S+) (?P<status>\d+) (?P<latency>\S+) (?P<cached>\w+)(?: (?P<user>\w+))?$`
+	types := map[string]FieldType{
+		"status":  FieldTypeInt,
+		"latency": FieldTypeFloat,
+		"cached":  FieldTypeBool,
+		"path":    FieldTypeString,
+	}
+	tests := []struct {
+		name    string
+		line    string
+		want    map[string]any
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name: "typed fields",
+			line: "10.0.0.1 GET /index.html 200 0.25 true bob",
+			want: map[string]any{
+				"ip":      "10.0.0.1",
+				"method":  "GET",
+				"path":    "/index.html",
+				"status":  int64(200),
+				"latency": 0.25,
+				"cached":  true,
+				"user":    "bob",
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "optional group doesn't participate and invalid value kept as string",
+			line: "10.0.0.1 GET /index.html 200 fast false",
+			want: map[string]any{
+				"ip":      "10.0.0.1",
+				"method":  "GET",
+				"path":    "/index.html",
+				"status":  int64(200),
+				"latency": "fast",
+				"cached":  false,
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "no match",
+			line:    "hello world",
+			wantErr: require.Error,
+		},
+	}
+	p, err := NewRegexParser(expr, types)
+	require.NoError(t, err)
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := p.Parse(tt.line)
+				tt.wantErr(t, err)
+				require.Equal(t, tt.want, got)
+			},
+		)
+	}
+}
+
+func TestNewRegexParser_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		expr  string
+		types map[string]FieldType
+	}{
+		{name: "invalid regex", expr: `(?P<a>`},
+		{name: "no named groups", expr: `(\w+) (\w+)`},
+		{name: "type for unknown group", expr: `(?P<a>\w+)`, types: map[string]FieldType{"b": FieldTypeInt}},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				_, err := NewRegexParser(tt.expr, tt.types)
+				require.Error(t, err)
+			},
+		)
+	}
+}
+
+func TestFormat_UnmarshalText(t *testing.T) {
+	tests := []struct {
+		text       string
+		want       Format
+		wantParser Parser
+		wantErr    require.ErrorAssertionFunc
+	}{
+		{text: "plain", want: FormatPlain, wantErr: require.NoError},
+		{text: "json", want: FormatJSON, wantParser: JSONParser{}, wantErr: require.NoError},
+		{text: "logfmt", want: FormatLogfmt, wantParser: LogfmtParser{}, wantErr: require.NoError},
+		{text: "foo", wantErr: require.Error},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.text, func(t *testing.T) {
+				var f Format
+				tt.wantErr(t, f.UnmarshalText([]byte(tt.text)))
+				require.Equal(t, tt.want, f)
+				require.Equal(t, tt.wantParser, f.Parser())
+			},
+		)
+	}
+}
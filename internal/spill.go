@@ -0,0 +1,303 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	spillQueueFile = "queue"
+	// The queue file is compacted into this file, which then replaces it.
+	spillCompactFile = "queue.compact"
+	// The length of the queue file's header, which holds the position of the
+	// next entry to consume.
+	spillOffsetLen = 8
+	// The length of each entry's header, which holds the length of the entry.
+	spillHeaderLen = 4
+	// The queue file is compacted once at least this many bytes at the head of
+	// it have been consumed, and they outweigh the unconsumed entries, so that
+	// a queue which never fully drains doesn't grow forever.
+	spillCompactThreshold = 1 << 20
+)
+
+// ErrSpillFull is returned by Spill.Push when the spill has reached its
+// maximum size.
+var ErrSpillFull = errors.New("spill is full")
+
+// Spill is a simple persistent FIFO queue of messages, backed by a directory
+// on disk. It is used to hold on to messages that couldn't be sent to the
+// destination so that they can be replayed later, even across restarts.
+//
+// Messages are appended to a single queue file as length-prefixed entries,
+// after a header which holds the position of the next unconsumed entry. Once
+// every entry has been consumed, the queue file is truncated. The consumed
+// entries are removed by writing the rest to a new file which replaces the
+// queue file, so that the entries and their position are always updated
+// together. Spill is not thread safe.
+type Spill struct {
+	dir      string   // The spill directory.
+	maxBytes int64    // The maximum number of pending bytes, 0 for unlimited.
+	queue    *os.File // The queue file.
+	head     int64    // The position of the next entry to consume.
+	tail     int64    // The position to append the next entry at.
+}
+
+// NewSpill opens the spill in the given directory, creating it if it doesn't
+// exist. Any messages left in the spill by a previous process are retained. If
+// the queue file ends with a partially written entry (e.g. due to a crash),
+// that entry is discarded.
+func NewSpill(dir string, maxBytes int64) (*Spill, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating spill directory: %w", err)
+	}
+	// A compaction which didn't finish is abandoned; the queue file is intact.
+	if err := os.Remove(filepath.Join(dir, spillCompactFile)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error removing spill compaction: %w", err)
+	}
+	queue, err := os.OpenFile(filepath.Join(dir, spillQueueFile), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening spill queue: %w", err)
+	}
+	s := &Spill{dir: dir, maxBytes: maxBytes, queue: queue}
+	if err := s.recover(); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// recover restores the spill's state from disk.
+func (s *Spill) recover() error {
+	info, err := s.queue.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading spill queue: %w", err)
+	}
+	size := info.Size()
+	if size < spillOffsetLen {
+		// A new (or empty) queue.
+		s.head, s.tail = spillOffsetLen, spillOffsetLen
+		if err := s.queue.Truncate(0); err != nil {
+			return fmt.Errorf("error truncating spill queue: %w", err)
+		}
+		return s.saveOffset()
+	}
+	var buf [spillOffsetLen]byte
+	if _, err := s.queue.ReadAt(buf[:], 0); err != nil {
+		return fmt.Errorf("error reading spill offset: %w", err)
+	}
+	s.head = int64(binary.BigEndian.Uint64(buf[:]))
+	if s.head < spillOffsetLen || s.head > size {
+		// The queue was truncated, but the offset wasn't reset.
+		s.head = spillOffsetLen
+	}
+	// Find the end of the last complete entry.
+	s.tail = s.head
+	for s.tail < size {
+		n, err := s.entryLen(s.tail)
+		if err != nil || s.tail+n > size {
+			break
+		}
+		s.tail += n
+	}
+	if s.tail < size {
+		if err := s.queue.Truncate(s.tail); err != nil {
+			return fmt.Errorf("error truncating spill queue: %w", err)
+		}
+	}
+	return nil
+}
+
+// Push appends the message to the end of the spill. If adding the message
+// would exceed the spill's maximum size, ErrSpillFull is returned.
+func (s *Spill) Push(msg Message) error {
+	buf, err := msg.MarshalMsg(make([]byte, spillHeaderLen, spillHeaderLen+len(msg.Line)+16))
+	if err != nil {
+		return fmt.Errorf("error encoding message: %w", err)
+	}
+	n := int64(len(buf))
+	if s.maxBytes > 0 && s.tail-s.head+n > s.maxBytes {
+		return ErrSpillFull
+	}
+	binary.BigEndian.PutUint32(buf, uint32(n-spillHeaderLen))
+	if _, err := s.queue.WriteAt(buf, s.tail); err != nil {
+		// Discard anything we may have partially written.
+		_ = s.queue.Truncate(s.tail)
+		return fmt.Errorf("error writing to spill queue: %w", err)
+	}
+	s.tail += n
+	return nil
+}
+
+// Peek returns the message at the head of the spill without removing it. The
+// boolean is false if the spill is empty.
+func (s *Spill) Peek() (Message, bool, error) {
+	msgs, err := s.PeekN(1)
+	if err != nil || len(msgs) == 0 {
+		return Message{}, false, err
+	}
+	return msgs[0], true, nil
+}
+
+// PeekN returns up to n messages from the head of the spill, in order, without
+// removing them. It returns fewer than n messages if the spill doesn't hold
+// that many.
+func (s *Spill) PeekN(n int) ([]Message, error) {
+	var msgs []Message
+	for pos := s.head; pos < s.tail && len(msgs) < n; {
+		entryLen, err := s.entryLen(pos)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, entryLen-spillHeaderLen)
+		if _, err := s.queue.ReadAt(buf, pos+spillHeaderLen); err != nil {
+			return nil, fmt.Errorf("error reading from spill queue: %w", err)
+		}
+		var msg Message
+		if _, err := msg.UnmarshalMsg(buf); err != nil {
+			return nil, fmt.Errorf("error decoding spilled message: %w", err)
+		}
+		msgs = append(msgs, msg)
+		pos += entryLen
+	}
+	return msgs, nil
+}
+
+// Pop removes the message at the head of the spill. It is a no-op if the spill
+// is empty.
+func (s *Spill) Pop() error {
+	return s.PopN(1)
+}
+
+// PopN removes up to n messages from the head of the spill, and commits their
+// removal to disk once for all of them.
+func (s *Spill) PopN(n int) error {
+	head := s.head
+	for i := 0; i < n && head < s.tail; i++ {
+		entryLen, err := s.entryLen(head)
+		if err != nil {
+			return err
+		}
+		head += entryLen
+	}
+	if head == s.head {
+		return nil
+	}
+	s.head = head
+	consumed := s.head - spillOffsetLen
+	switch {
+	case s.Empty():
+		// Everything has been consumed - start over.
+		if err := s.queue.Truncate(spillOffsetLen); err != nil {
+			return fmt.Errorf("error truncating spill queue: %w", err)
+		}
+		s.head, s.tail = spillOffsetLen, spillOffsetLen
+	case consumed >= spillCompactThreshold && consumed >= s.tail-s.head:
+		// Compacting rewrites the unconsumed entries, so only do it once there
+		// are at least as many consumed bytes to reclaim.
+		if err := s.compact(); err != nil {
+			// Keep the consumed entries, but not the position within them.
+			return errors.Join(err, s.saveOffset())
+		}
+		return nil
+	}
+	return s.saveOffset()
+}
+
+// Sync commits the spill to disk, so that the messages pushed to it survive a
+// crash of the system.
+func (s *Spill) Sync() error {
+	if err := s.queue.Sync(); err != nil {
+		return fmt.Errorf("error syncing spill queue: %w", err)
+	}
+	return nil
+}
+
+// Empty returns true if there are no messages in the spill.
+func (s *Spill) Empty() bool {
+	return s.head >= s.tail
+}
+
+// Close closes the spill's queue file. Any messages remaining in the spill are
+// retained on disk.
+func (s *Spill) Close() error {
+	return s.queue.Close()
+}
+
+// entryLen returns the total length (including the header) of the entry at the
+// given position.
+func (s *Spill) entryLen(pos int64) (int64, error) {
+	var hdr [spillHeaderLen]byte
+	if _, err := s.queue.ReadAt(hdr[:], pos); err != nil {
+		return 0, fmt.Errorf("error reading from spill queue: %w", err)
+	}
+	return spillHeaderLen + int64(binary.BigEndian.Uint32(hdr[:])), nil
+}
+
+// compact removes the consumed entries from the queue file, by writing the
+// unconsumed ones to a new file which then replaces it. If the system crashes
+// before it is replaced, the queue file is left as it was.
+func (s *Spill) compact() error {
+	buf := make([]byte, spillOffsetLen+s.tail-s.head)
+	binary.BigEndian.PutUint64(buf, spillOffsetLen)
+	if _, err := s.queue.ReadAt(buf[spillOffsetLen:], s.head); err != nil {
+		return fmt.Errorf("error reading from spill queue: %w", err)
+	}
+	path := filepath.Join(s.dir, spillCompactFile)
+	if err := writeFileSync(path, buf); err != nil {
+		_ = os.Remove(path)
+		return fmt.Errorf("error compacting spill queue: %w", err)
+	}
+	// The queue file must be closed before it can be replaced on Windows.
+	if err := s.queue.Close(); err != nil {
+		return fmt.Errorf("error closing spill queue: %w", err)
+	}
+	renameErr := os.Rename(path, filepath.Join(s.dir, spillQueueFile))
+	queue, err := os.OpenFile(filepath.Join(s.dir, spillQueueFile), os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("error opening spill queue: %w", errors.Join(renameErr, err))
+	}
+	s.queue = queue
+	if renameErr != nil {
+		// The queue file is still the old one.
+		_ = os.Remove(path)
+		return fmt.Errorf("error compacting spill queue: %w", renameErr)
+	}
+	if err := syncDir(s.dir); err != nil {
+		return fmt.Errorf("error syncing spill directory: %w", err)
+	}
+	s.head, s.tail = spillOffsetLen, int64(len(buf))
+	return nil
+}
+
+// saveOffset writes the position of the next entry to consume to the header of
+// the queue file, and commits it to disk.
+func (s *Spill) saveOffset() error {
+	var buf [spillOffsetLen]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(s.head))
+	if _, err := s.queue.WriteAt(buf[:], 0); err != nil {
+		return fmt.Errorf("error writing spill offset: %w", err)
+	}
+	return s.Sync()
+}
+
+// writeFileSync writes data to a new file at the given path, and commits it to
+// disk.
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
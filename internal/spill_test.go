@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
+)
+
+func TestSpill_PushPeekPop(t *testing.T) {
+	s, err := NewSpill(t.TempDir(), 0)
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+	require.True(t, s.Empty())
+	msgs := []Message{
+		{Line: "line1"},
+		{Line: ""},
+		{Line: `{"foo":"bar","n":1}`, Fields: map[string]any{"foo": "bar", "n": int64(1)}},
+		{Line: "timed", Time: time.Unix(1709296245, 123456789)},
+	}
+	for _, msg := range msgs {
+		require.NoError(t, s.Push(msg))
+	}
+	require.False(t, s.Empty())
+	require.Equal(t, msgs, drainSpill(t, s))
+	require.True(t, s.Empty())
+	// The queue is truncated once it has been fully consumed.
+	info, err := s.queue.Stat()
+	require.NoError(t, err)
+	require.EqualValues(t, spillOffsetLen, info.Size())
+}
+
+func TestSpill_Peek_Empty(t *testing.T) {
+	s, err := NewSpill(t.TempDir(), 0)
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+	_, ok, err := s.Peek()
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NoError(t, s.Pop())
+}
+
+func TestSpill_PeekNPopN(t *testing.T) {
+	s, err := NewSpill(t.TempDir(), 0)
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+	for _, line := range []string{"line1", "line2", "line3"} {
+		require.NoError(t, s.Push(Message{Line: line}))
+	}
+	msgs, err := s.PeekN(2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"line1", "line2"}, lines(msgs))
+	require.NoError(t, s.PopN(2))
+	// Fewer messages than requested are returned, and removed, at the end.
+	msgs, err = s.PeekN(2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"line3"}, lines(msgs))
+	require.NoError(t, s.PopN(2))
+	require.True(t, s.Empty())
+	msgs, err = s.PeekN(2)
+	require.NoError(t, err)
+	require.Empty(t, msgs)
+}
+
+func TestSpill_Push_Full(t *testing.T) {
+	// Room for exactly two 1-byte messages.
+	entry, err := Message{Line: "1"}.MarshalMsg(nil)
+	require.NoError(t, err)
+	s, err := NewSpill(t.TempDir(), int64(2*(spillHeaderLen+len(entry))))
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+	require.NoError(t, s.Push(Message{Line: "1"}))
+	require.NoError(t, s.Push(Message{Line: "2"}))
+	require.ErrorIs(t, s.Push(Message{Line: "3"}), ErrSpillFull)
+	// Consuming a message makes room for another.
+	require.NoError(t, s.Pop())
+	require.NoError(t, s.Push(Message{Line: "3"}))
+	require.Equal(t, []string{"2", "3"}, lines(drainSpill(t, s)))
+}
+
+func TestSpill_SurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSpill(dir, 0)
+	require.NoError(t, err)
+	for _, line := range []string{"line1", "line2", "line3"} {
+		require.NoError(t, s.Push(Message{Line: line}))
+	}
+	require.NoError(t, s.Pop())
+	require.NoError(t, s.Close())
+
+	s, err = NewSpill(dir, 0)
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+	require.Equal(t, []string{"line2", "line3"}, lines(drainSpill(t, s)))
+}
+
+func TestSpill_DiscardsPartialEntryOnReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSpill(dir, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.Push(Message{Line: "line1"}))
+	require.NoError(t, s.Close())
+	// Simulate a crash in the middle of writing an entry.
+	f, err := os.OpenFile(filepath.Join(dir, spillQueueFile), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 10, 'l', 'i'})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	s, err = NewSpill(dir, 0)
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+	require.NoError(t, s.Push(Message{Line: "line2"}))
+	require.Equal(t, []string{"line1", "line2"}, lines(drainSpill(t, s)))
+}
+
+func TestSpill_Compacts(t *testing.T) {
+	s, err := NewSpill(t.TempDir(), 0)
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+	msg := largeString(spillCompactThreshold / 4)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Push(Message{Line: msg}))
+	}
+	require.NoError(t, s.Push(Message{Line: "last"}))
+	for i := 0; i < 4; i++ {
+		require.NoError(t, s.Pop())
+	}
+	// The consumed entries at the head of the queue have been removed.
+	require.EqualValues(t, spillOffsetLen, s.head)
+	_, err = os.Stat(filepath.Join(s.dir, spillCompactFile))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.Equal(t, []string{msg, "last"}, lines(drainSpill(t, s)))
+}
+
+func TestSpill_Compacts_OnlyOnceConsumedOutweighsRemaining(t *testing.T) {
+	s, err := NewSpill(t.TempDir(), 0)
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+	msg := largeString(spillCompactThreshold / 4)
+	for i := 0; i < 12; i++ {
+		require.NoError(t, s.Push(Message{Line: msg}))
+	}
+	// The threshold has been consumed, but more than that remains.
+	require.NoError(t, s.PopN(5))
+	require.Greater(t, s.head, int64(spillCompactThreshold))
+	require.NoError(t, s.PopN(1))
+	// Now half of the queue has been consumed.
+	require.EqualValues(t, spillOffsetLen, s.head)
+	require.Len(t, drainSpill(t, s), 6)
+}
+
+func TestSpill_Compacts_SurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSpill(dir, 0)
+	require.NoError(t, err)
+	msg := largeString(spillCompactThreshold / 4)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Push(Message{Line: msg}))
+	}
+	require.NoError(t, s.Push(Message{Line: "last"}))
+	for i := 0; i < 4; i++ {
+		require.NoError(t, s.Pop())
+	}
+	require.NoError(t, s.Close())
+
+	s, err = NewSpill(dir, 0)
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+	require.Equal(t, []string{msg, "last"}, lines(drainSpill(t, s)))
+}
+
+func TestSpill_DiscardsUnfinishedCompactionOnReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSpill(dir, 0)
+	require.NoError(t, err)
+	for _, line := range []string{"line1", "line2"} {
+		require.NoError(t, s.Push(Message{Line: line}))
+	}
+	require.NoError(t, s.Pop())
+	require.NoError(t, s.Close())
+	// Simulate a crash before the compacted queue replaced the queue file.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, spillCompactFile), []byte("garbage"), 0o600))
+
+	s, err = NewSpill(dir, 0)
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+	require.Equal(t, []string{"line2"}, lines(drainSpill(t, s)))
+	_, err = os.Stat(filepath.Join(dir, spillCompactFile))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func drainSpill(t *testing.T, s *Spill) []Message {
+	var msgs []Message
+	for {
+		msg, ok, err := s.Peek()
+		require.NoError(t, err)
+		if !ok {
+			return msgs
+		}
+		msgs = append(msgs, msg)
+		require.NoError(t, s.Pop())
+	}
+}
+
+func TestMessage_UnmarshalMsg_InvalidLength(t *testing.T) {
+	b := msgp.AppendArrayHeader(nil, 2)
+	b = msgp.AppendString(b, "line")
+	b = msgp.AppendNil(b)
+	var msg Message
+	_, err := msg.UnmarshalMsg(b)
+	require.ErrorContains(t, err, "expected 3 elements, got 2")
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package internal
+
+import "os"
+
+// syncDir commits the entries of the directory to disk, e.g. after a file in it
+// has been renamed.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
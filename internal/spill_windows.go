@@ -0,0 +1,7 @@
+package internal
+
+// syncDir is a no-op on Windows, where directories can't be synced, and renames
+// are committed to disk with the file.
+func syncDir(string) error {
+	return nil
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"io"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// DropReason is why a Forwarder dropped a message.
+type DropReason string
+
+const (
+	// DropBufferFull means the message buffer was full.
+	DropBufferFull DropReason = "buffer_full"
+	// DropTooLong means the line was longer than the maximum line length.
+	DropTooLong DropReason = "too_long"
+	// DropConnectFailed means the Logger couldn't connect.
+	DropConnectFailed DropReason = "connect_failed"
+	// DropSendFailed means the Logger couldn't send the message.
+	DropSendFailed DropReason = "send_failed"
+	// DropSpillFailed means the message couldn't be spilled.
+	DropSpillFailed DropReason = "spill_failed"
+)
+
+// DropReasons are all the reasons a Forwarder drops messages.
+var DropReasons = []DropReason{DropBufferFull, DropTooLong, DropConnectFailed, DropSendFailed, DropSpillFailed}
+
+// LatencyBuckets are the upper bounds of the buckets of a Forwarder's send
+// latency histogram.
+var LatencyBuckets = [...]time.Duration{
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Stats is a snapshot of a Forwarder's counters, which count from when it was
+// created.
+type Stats struct {
+	LinesRead  uint64                // Lines read from the source.
+	BytesRead  uint64                // Bytes read from the source.
+	LinesSent  uint64                // Messages sent to the Logger.
+	Reconnects uint64                // Times the Logger was reconnected.
+	Dropped    map[DropReason]uint64 // Messages dropped, by reason.
+	// SendLatency is how long sending messages (or batches) to the Logger took.
+	SendLatency Histogram
+	QueueLen    int // Messages currently buffered.
+	QueueCap    int // The size of the message buffer.
+}
+
+// TotalDropped returns the number of messages dropped for any reason.
+func (s Stats) TotalDropped() uint64 {
+	var n uint64
+	for _, dropped := range s.Dropped {
+		n += dropped
+	}
+	return n
+}
+
+// Histogram is a snapshot of a latency histogram.
+type Histogram struct {
+	// Counts are the number of observations in each of the LatencyBuckets,
+	// i.e. no greater than its upper bound, cumulatively.
+	Counts [len(LatencyBuckets)]uint64
+	Count  uint64        // The total number of observations.
+	Sum    time.Duration // The sum of the observations.
+}
+
+// stats are a Forwarder's counters, which are safe for concurrent use. The
+// zero value is ready to use.
+type stats struct {
+	linesRead  atomic.Uint64
+	bytesRead  atomic.Uint64
+	linesSent  atomic.Uint64
+	reconnects atomic.Uint64
+	dropped    [5]atomic.Uint64 // Indexed like DropReasons.
+	latency    [len(LatencyBuckets) + 1]atomic.Uint64
+	latencySum atomic.Int64
+}
+
+// drop counts n messages dropped for the given reason.
+func (s *stats) drop(reason DropReason, n int) {
+	s.dropped[slices.Index(DropReasons, reason)].Add(uint64(n))
+}
+
+// observe adds a send latency to the histogram.
+func (s *stats) observe(d time.Duration) {
+	i, _ := slices.BinarySearch(LatencyBuckets[:], d)
+	s.latency[i].Add(1)
+	s.latencySum.Add(int64(d))
+}
+
+// snapshot returns the current values of the counters.
+func (s *stats) snapshot() Stats {
+	snap := Stats{
+		LinesRead:  s.linesRead.Load(),
+		BytesRead:  s.bytesRead.Load(),
+		LinesSent:  s.linesSent.Load(),
+		Reconnects: s.reconnects.Load(),
+		Dropped:    make(map[DropReason]uint64, len(DropReasons)),
+	}
+	for i, reason := range DropReasons {
+		snap.Dropped[reason] = s.dropped[i].Load()
+	}
+	var count uint64
+	for i := range s.latency {
+		count += s.latency[i].Load()
+		if i < len(LatencyBuckets) {
+			snap.SendLatency.Counts[i] = count
+		}
+	}
+	snap.SendLatency.Count = count
+	snap.SendLatency.Sum = time.Duration(s.latencySum.Load())
+	return snap
+}
+
+// countingReader is an io.Reader which counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Uint64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(uint64(n))
+	return n, err
+}
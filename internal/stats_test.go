@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwarder_Stats(t *testing.T) {
+	logger := NewMockLogger(t)
+	logger.On("IsConnected").Return(true).Once()
+	logger.On("IsConnected").Return(false).Once()
+	logger.On("IsConnected").Return(false).Once()
+	logger.On("Connect").Return(errors.New("error")).Once()
+	logger.On("Connect").Return(nil).Once()
+	logger.On("Log", mock.Anything).Return(nil).Twice()
+	logger.On("Disconnect").Return(nil).Once()
+	f := &Forwarder{
+		name:   "name",
+		bufLen: 3,
+		src:    io.NopCloser(strings.NewReader("line1\nline2\nline3\n")),
+		logger: logger,
+	}
+	f.Forward()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	require.NoError(t, f.Wait(ctx))
+
+	stats := f.Stats()
+	require.EqualValues(t, 3, stats.LinesRead)
+	require.EqualValues(t, 18, stats.BytesRead)
+	require.EqualValues(t, 2, stats.LinesSent)
+	require.EqualValues(t, 1, stats.Reconnects)
+	require.EqualValues(t, 1, stats.Dropped[DropConnectFailed])
+	require.EqualValues(t, 1, stats.TotalDropped())
+	require.EqualValues(t, 2, stats.SendLatency.Count)
+	require.Equal(t, 0, stats.QueueLen)
+	require.Equal(t, 3, stats.QueueCap)
+}
+
+func TestForwarder_Stats_BufferFull(t *testing.T) {
+	f := &Forwarder{name: "dummy", src: io.NopCloser(strings.NewReader("1\n2\n3\n"))}
+	ch := make(chan Message, 2)
+	require.NoError(t, f.readLines(ch))
+	stats := f.Stats()
+	require.EqualValues(t, 3, stats.LinesRead)
+	require.EqualValues(t, 1, stats.Dropped[DropBufferFull])
+}
+
+func TestForwarder_Stats_TooLong(t *testing.T) {
+	f := &Forwarder{
+		name:         "dummy",
+		maxLineBytes: 3,
+		longLines:    LongLineDrop,
+		src:          io.NopCloser(strings.NewReader("1\n12345\n3\n")),
+	}
+	ch := make(chan Message, 3)
+	require.NoError(t, f.readLines(ch))
+	stats := f.Stats()
+	require.EqualValues(t, 3, stats.LinesRead)
+	require.EqualValues(t, 1, stats.Dropped[DropTooLong])
+}
+
+func Test_stats_observe(t *testing.T) {
+	var s stats
+	s.observe(500 * time.Microsecond)
+	s.observe(time.Millisecond)
+	s.observe(3 * time.Millisecond)
+	s.observe(time.Minute)
+	h := s.snapshot().SendLatency
+	require.EqualValues(t, 4, h.Count)
+	require.Equal(t, time.Minute+4500*time.Microsecond, h.Sum)
+	// Buckets are cumulative, and include their upper bound.
+	require.EqualValues(t, 2, h.Counts[0])
+	require.EqualValues(t, 2, h.Counts[1])
+	require.EqualValues(t, 3, h.Counts[2])
+	require.EqualValues(t, 3, h.Counts[len(h.Counts)-1])
+}
+
+func TestWriteMetrics(t *testing.T) {
+	f := &Forwarder{name: "stdout", msgs: make(chan Message, 8)}
+	f.msgs <- Message{Line: "queued"}
+	f.stats.linesRead.Add(5)
+	f.stats.bytesRead.Add(42)
+	f.stats.linesSent.Add(3)
+	f.stats.drop(DropBufferFull, 2)
+	f.stats.observe(2 * time.Millisecond)
+	var buf bytes.Buffer
+	require.NoError(t, WriteMetrics(&buf, []*Forwarder{f}))
+	for _, want := range []string{
+		"# TYPE log2fluent_lines_read_total counter\n",
+		`log2fluent_lines_read_total{stream="stdout"} 5` + "\n",
+		`log2fluent_bytes_read_total{stream="stdout"} 42` + "\n",
+		`log2fluent_lines_sent_total{stream="stdout"} 3` + "\n",
+		`log2fluent_reconnects_total{stream="stdout"} 0` + "\n",
+		`log2fluent_lines_dropped_total{stream="stdout",reason="buffer_full"} 2` + "\n",
+		`log2fluent_lines_dropped_total{stream="stdout",reason="send_failed"} 0` + "\n",
+		"# TYPE log2fluent_send_duration_seconds histogram\n",
+		`log2fluent_send_duration_seconds_bucket{stream="stdout",le="0.001"} 0` + "\n",
+		`log2fluent_send_duration_seconds_bucket{stream="stdout",le="0.0025"} 1` + "\n",
+		`log2fluent_send_duration_seconds_bucket{stream="stdout",le="+Inf"} 1` + "\n",
+		`log2fluent_send_duration_seconds_sum{stream="stdout"} 0.002` + "\n",
+		`log2fluent_send_duration_seconds_count{stream="stdout"} 1` + "\n",
+		`log2fluent_queue_length{stream="stdout"} 1` + "\n",
+		`log2fluent_queue_capacity{stream="stdout"} 8` + "\n",
+	} {
+		require.Contains(t, buf.String(), want)
+	}
+}
+
+func Test_labelValue(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{name: "plain", s: "stdout", want: `"stdout"`},
+		{name: "escaped", s: "a\\b\"c\nd", want: `"a\\b\"c\nd"`},
+		{name: "non-ascii", s: "café", want: `"café"`},
+		{name: "tab", s: "a\tb", want: "\"a\tb\""},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				require.Equal(t, tt.want, labelValue(tt.s))
+			},
+		)
+	}
+}
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"io"
+	"log/slog"
+	"sync/atomic"
+)
+
+// The number of chunks a Tee buffers before it starts dropping them.
+const teeBufLen = 1024
+
+// Tee is an io.Writer which copies everything written to it to another writer
+// in the background. Writes to a Tee never block: if the other writer falls
+// behind (e.g. a slow terminal) and the Tee's buffer fills up, the data is
+// dropped instead.
+type Tee struct {
+	w       io.Writer
+	chunks  chan []byte
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+// NewTee returns a Tee which copies to w.
+func NewTee(w io.Writer) *Tee {
+	t := &Tee{w: w, chunks: make(chan []byte, teeBufLen), done: make(chan struct{})}
+	go func() {
+		defer close(t.done)
+		for chunk := range t.chunks {
+			if _, err := t.w.Write(chunk); err != nil {
+				slog.Debug("error writing to tee", "error", err)
+			}
+		}
+	}()
+	return t
+}
+
+// Write queues a copy of p to be written to the Tee's writer, or drops it if
+// the Tee's buffer is full. It always succeeds.
+func (t *Tee) Write(p []byte) (int, error) {
+	select {
+	case t.chunks <- append([]byte(nil), p...):
+	default:
+		t.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// Dropped returns the number of chunks dropped so far because the Tee's buffer
+// was full.
+func (t *Tee) Dropped() uint64 {
+	return t.dropped.Load()
+}
+
+// Close waits until everything buffered has been written to the Tee's writer.
+// The Tee must not be written to afterward. The underlying writer is not
+// closed.
+func (t *Tee) Close() error {
+	close(t.chunks)
+	<-t.done
+	return nil
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTee_Write(t *testing.T) {
+	var buf bytes.Buffer
+	tee := NewTee(&buf)
+	p := []byte("hello\n")
+	n, err := tee.Write(p)
+	require.NoError(t, err)
+	require.Equal(t, len(p), n)
+	// The Tee must not hold on to the caller's buffer.
+	copy(p, "XXXXX\n")
+	_, err = tee.Write([]byte("progress"))
+	require.NoError(t, err)
+	require.NoError(t, tee.Close())
+	require.Equal(t, "hello\nprogress", buf.String())
+	require.Zero(t, tee.Dropped())
+}
+
+func TestTee_Write_SlowWriterDropsInsteadOfBlocking(t *testing.T) {
+	r, w := io.Pipe()
+	tee := NewTee(w)
+	// Nothing reads from the pipe, so the Tee's writer blocks, and its buffer
+	// fills up.
+	for i := 0; i < teeBufLen+10; i++ {
+		_, err := tee.Write([]byte("line\n"))
+		require.NoError(t, err)
+	}
+	require.GreaterOrEqual(t, tee.Dropped(), uint64(9))
+	// Unblock the writer so the Tee can be closed.
+	go func() { _, _ = io.Copy(io.Discard, r) }()
+	require.NoError(t, tee.Close())
+}
+
+func TestForwarder_Forward_Tee(t *testing.T) {
+	logger := NewMockLogger(t)
+	logger.On("Connect").Return(nil)
+	logger.On("IsConnected").Return(true)
+	logger.On("Log", mock.MatchedBy(func(msg Message) bool { return msg.Line == "hello" })).Return(nil)
+	logger.On("Disconnect").Return(nil)
+	var buf bytes.Buffer
+	r, w := io.Pipe()
+	f := NewForwarder("dummy", r, logger, ForwarderOptions{BufLen: 1, Tee: NewTee(&buf)})
+	f.Forward()
+	_, err := w.Write([]byte("hello\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Wait(context.Background()))
+	require.Equal(t, "hello\n", buf.String())
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSOptions holds the settings used to build the TLS configuration for
+// connections to a fluent destination.
+type TLSOptions struct {
+	// CAFile is the path to a PEM encoded CA certificate bundle used to verify
+	// the server's certificate. If empty, the system's root CAs are used.
+	CAFile string
+	// CertFile and KeyFile are the paths to a PEM encoded client certificate
+	// and its private key, used for mutual TLS. Both or neither must be set.
+	CertFile, KeyFile string
+	// ServerName is the name used to verify the server's certificate. If
+	// empty, the host of the destination address is used.
+	ServerName string
+	// InsecureSkipVerify disables verification of the server's certificate.
+	// This should only be used for testing.
+	InsecureSkipVerify bool
+}
+
+// NewTLSConfig returns a tls.Config based on the given options. An error is
+// returned if any of the certificate files can't be loaded.
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", opts.CAFile)
+		}
+	}
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.New("both a client certificate and key are required for mutual TLS")
+	}
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,225 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IBM/fluent-forward-go/fluent/client"
+	"github.com/stretchr/testify/require"
+)
+
+// testPKI is a throwaway CA and a server and client certificate signed by it,
+// all written to PEM files in a temporary directory.
+type testPKI struct {
+	caFile, serverCertFile, serverKeyFile, clientCertFile, clientKeyFile string
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir := t.TempDir()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+	pki := &testPKI{caFile: filepath.Join(dir, "ca.pem")}
+	writePEM(t, pki.caFile, "CERTIFICATE", caDER)
+
+	issue := func(name string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			DNSNames:     []string{name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+		certFile := filepath.Join(dir, name+".pem")
+		keyFile := filepath.Join(dir, name+"-key.pem")
+		writePEM(t, certFile, "CERTIFICATE", der)
+		writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+		return certFile, keyFile
+	}
+	pki.serverCertFile, pki.serverKeyFile = issue("fluent.test", 2, x509.ExtKeyUsageServerAuth)
+	pki.clientCertFile, pki.clientKeyFile = issue("client.test", 3, x509.ExtKeyUsageClientAuth)
+	return pki
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+}
+
+// tlsServer starts a local TLS server which requires and verifies client
+// certificates, and discards everything it receives. It returns the server's
+// address.
+func tlsServer(t *testing.T, pki *testPKI) string {
+	cert, err := tls.LoadX509KeyPair(pki.serverCertFile, pki.serverKeyFile)
+	require.NoError(t, err)
+	caCfg, err := NewTLSConfig(TLSOptions{CAFile: pki.caFile})
+	require.NoError(t, err)
+	l, err := tls.Listen(
+		"tcp", "127.0.0.1:0", &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    caCfg.RootCAs,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		},
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(io.Discard, conn) }()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	pki := newTestPKI(t)
+	tests := []struct {
+		name            string
+		opts            TLSOptions
+		wantErr         require.ErrorAssertionFunc
+		otherAssertions func(t *testing.T, cfg *tls.Config)
+	}{
+		{
+			name:    "defaults",
+			wantErr: require.NoError,
+			otherAssertions: func(t *testing.T, cfg *tls.Config) {
+				require.Nil(t, cfg.RootCAs)
+				require.Empty(t, cfg.Certificates)
+				require.False(t, cfg.InsecureSkipVerify)
+			},
+		},
+		{
+			name: "all options",
+			opts: TLSOptions{
+				CAFile:             pki.caFile,
+				CertFile:           pki.clientCertFile,
+				KeyFile:            pki.clientKeyFile,
+				ServerName:         "fluent.test",
+				InsecureSkipVerify: true,
+			},
+			wantErr: require.NoError,
+			otherAssertions: func(t *testing.T, cfg *tls.Config) {
+				require.NotNil(t, cfg.RootCAs)
+				require.Len(t, cfg.Certificates, 1)
+				require.Equal(t, "fluent.test", cfg.ServerName)
+				require.True(t, cfg.InsecureSkipVerify)
+			},
+		},
+		{
+			name:    "missing CA file",
+			opts:    TLSOptions{CAFile: "does-not-exist.pem"},
+			wantErr: require.Error,
+		},
+		{
+			name:    "invalid CA file",
+			opts:    TLSOptions{CAFile: pki.clientKeyFile},
+			wantErr: require.Error,
+		},
+		{
+			name:    "cert without key",
+			opts:    TLSOptions{CertFile: pki.clientCertFile},
+			wantErr: require.Error,
+		},
+		{
+			name:    "mismatched cert and key",
+			opts:    TLSOptions{CertFile: pki.clientCertFile, KeyFile: pki.serverKeyFile},
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				cfg, err := NewTLSConfig(tt.opts)
+				tt.wantErr(t, err)
+				if tt.otherAssertions != nil {
+					tt.otherAssertions(t, cfg)
+				}
+			},
+		)
+	}
+}
+
+func TestFluentLogger_TLS(t *testing.T) {
+	pki := newTestPKI(t)
+	addr := tlsServer(t, pki)
+	tests := []struct {
+		name    string
+		opts    TLSOptions
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name: "mutual TLS",
+			opts: TLSOptions{
+				CAFile:     pki.caFile,
+				CertFile:   pki.clientCertFile,
+				KeyFile:    pki.clientKeyFile,
+				ServerName: "fluent.test",
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "unknown CA",
+			opts:    TLSOptions{ServerName: "fluent.test"},
+			wantErr: require.Error,
+		},
+		{
+			name:    "wrong server name",
+			opts:    TLSOptions{CAFile: pki.caFile, ServerName: "other.test"},
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				cfg, err := NewTLSConfig(tt.opts)
+				require.NoError(t, err)
+				logger := NewFluentLogger(
+					"tls", addr, "tag", "stdout", nil, FluentLoggerOptions{TLSConfig: cfg},
+				)
+				tt.wantErr(t, logger.Connect())
+				_ = logger.Disconnect()
+			},
+		)
+	}
+}
+
+func TestNewFluentLogger_TLS_UsesTCP(t *testing.T) {
+	l := NewFluentLogger("tls", "localhost:24224", "", "", nil, FluentLoggerOptions{})
+	factory := l.c.(*client.Client).ConnectionFactory.(*client.ConnFactory)
+	require.Equal(t, "tcp", factory.Network)
+	require.NotNil(t, factory.TLSConfig)
+}
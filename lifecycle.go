@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// lifecycle reports the child process's lifecycle events - when it starts,
+// exits and is restarted - by writing them as JSON lines to w, which is read
+// by a Forwarder like any other stream. A nil lifecycle reports nothing.
+type lifecycle struct {
+	w       io.WriteCloser
+	command []string
+}
+
+// started reports that the child process started, after the given number of
+// restarts.
+func (l *lifecycle) started(child *os.Process, restarts int) {
+	l.emit("start", map[string]any{"pid": child.Pid, "restarts": restarts})
+}
+
+// startFailed reports that the child process couldn't be restarted.
+func (l *lifecycle) startFailed(err error, restarts int) {
+	l.emit("start_failed", map[string]any{"error": err.Error(), "restarts": restarts})
+}
+
+// exited reports that the child process exited, after running for the given
+// time. Its exit code is -1 if it was terminated by a signal.
+func (l *lifecycle) exited(state *os.ProcessState, ran time.Duration) {
+	fields := map[string]any{
+		"pid":                state.Pid(),
+		"exit_code":          state.ExitCode(),
+		"duration_seconds":   ran.Seconds(),
+		"user_cpu_seconds":   state.UserTime().Seconds(),
+		"system_cpu_seconds": state.SystemTime().Seconds(),
+	}
+	if sig := exitSignal(state); sig != "" {
+		fields["signal"] = sig
+	}
+	if rss, ok := maxRSS(state); ok {
+		fields["max_rss_bytes"] = rss
+	}
+	l.emit("exit", fields)
+}
+
+// restarting reports that the child process will be restarted after the given
+// delay.
+func (l *lifecycle) restarting(delay time.Duration, restarts int) {
+	l.emit("restart", map[string]any{"delay_seconds": delay.Seconds(), "restarts": restarts})
+}
+
+// Close stops reporting events.
+func (l *lifecycle) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.w.Close()
+}
+
+func (l *lifecycle) emit(event string, fields map[string]any) {
+	if l == nil {
+		return
+	}
+	fields["event"] = event
+	fields["command"] = l.command
+	b, err := json.Marshal(fields)
+	if err != nil {
+		slog.Error("error encoding lifecycle event", "event", event, "error", err)
+		return
+	}
+	if _, err := l.w.Write(append(b, '\n')); err != nil {
+		slog.Error("error writing lifecycle event", "event", event, "error", err)
+	}
+}
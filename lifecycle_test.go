@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// events returns the events written to buf.
+func events(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var events []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var event map[string]any
+		require.NoError(t, dec.Decode(&event))
+		events = append(events, event)
+	}
+	return events
+}
+
+func Test_lifecycle(t *testing.T) {
+	var buf bytes.Buffer
+	l := &lifecycle{w: nopWriteCloser{&buf}, command: []string{"app", "-v"}}
+	l.started(&os.Process{Pid: 42}, 0)
+	l.restarting(1500*time.Millisecond, 1)
+	l.startFailed(errors.New("no such file"), 1)
+	require.Equal(
+		t,
+		[]map[string]any{
+			{"event": "start", "command": []any{"app", "-v"}, "pid": float64(42), "restarts": float64(0)},
+			{"event": "restart", "command": []any{"app", "-v"}, "delay_seconds": 1.5, "restarts": float64(1)},
+			{"event": "start_failed", "command": []any{"app", "-v"}, "error": "no such file", "restarts": float64(1)},
+		},
+		events(t, &buf),
+	)
+}
+
+func Test_lifecycle_Nil(t *testing.T) {
+	var l *lifecycle
+	l.started(&os.Process{Pid: 42}, 0)
+	l.restarting(time.Second, 1)
+	require.NoError(t, l.Close())
+}
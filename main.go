@@ -1,11 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/ccampo133/log2fluent/internal"
 )
@@ -14,10 +26,14 @@ func usage() {
 	_, _ = fmt.Fprint(
 		flag.CommandLine.Output(),
 		`Usage: log2fluent [options] <command> [args...]
+       log2fluent [options] validate-config <path>
 
 Execute a command, capture its stdout and/or stderr logs, and forward those logs
 to Fluent via the Fluent Forward Protocol.
 
+The validate-config command checks a config file (see -config), reporting any
+errors with their line numbers, without running anything.
+
 Supported options:
 `,
 	)
@@ -30,14 +46,50 @@ var version = "latest"
 
 func main() {
 	var (
+		configPath       string
+		streams          fdFlags
 		tag              string
 		outDest, errDest string
-		outPipe, errPipe *pipe
+		extraFds         fdFlags
+		teeStdout        teeFlag
+		teeStderr        teeFlag
 		extraAttrs       string
 		bufLen           uint
+		backpressure     = internal.BackpressureDrop
+		batchSize        uint
+		batchBytes       uint
+		flushInterval    time.Duration
+		batchMode        = internal.BatchModePacked
+		compression      = internal.CompressionNone
+		requireAck       bool
+		ackTimeout       time.Duration
+		connectTimeout   time.Duration
+		tlsOpts          internal.TLSOptions
+		authOpts         internal.AuthOptions
+		passwordFile     string
+		spillDir         string
+		spillMaxBytes    int64
+		drainTimeout     time.Duration
+		killTimeout      time.Duration
+		signalGroup      bool
+		lifecycleEvents  bool
+		metricsAddr      string
+		statsInterval    time.Duration
+		healthAddr       string
+		readyMaxBuffer   float64
+		running          atomic.Bool
+		sup              = supervisor{policy: RestartNever}
 		debugEnabled     bool
 		printVersion     bool
 		fwdrs            []*internal.Forwarder
+		defaults         = newStreamSettings()
+	)
+	defaults.register(flag.CommandLine)
+	flag.StringVar(
+		&configPath,
+		"config",
+		"",
+		"path to a YAML (.yaml or .yml), TOML (.toml) or JSON (.json) config file.\nIts keys are the names of these flags, and its \"streams\" key configures\nindividual streams. Flags given on the command line take precedence.",
 	)
 	flag.StringVar(
 		&tag,
@@ -49,13 +101,28 @@ func main() {
 		&outDest,
 		"stdout",
 		"",
-		"fluent-bit address for forwarding stdout ([network://]addr).",
+		"fluent-bit address for forwarding stdout ([network://]addr). The network\nmay be tcp (default), udp, unix or tls. Several comma separated addresses\nmay be given, which are used according to -mode.",
 	)
 	flag.StringVar(
 		&errDest,
 		"stderr",
 		"",
-		"fluent-bit address for forwarding stderr ([network://]addr).",
+		"fluent-bit address for forwarding stderr ([network://]addr). The network\nmay be tcp (default), udp, unix or tls. Several comma separated addresses\nmay be given, which are used according to -mode.",
+	)
+	flag.Var(
+		&extraFds,
+		"fd",
+		"capture an additional file descriptor of the child and forward it to a\nfluent-bit address (N=[network://]addr[,tag=...][,stream=...]), e.g.\n3=localhost:24224,stream=audit. The stream name defaults to fdN. Several\naddresses may be given, as with -stdout. May be repeated. Not supported on\nWindows.",
+	)
+	flag.Var(
+		&teeStdout,
+		"tee-stdout",
+		"also echo the child's stdout to log2fluent's own stdout, or to a file if\ngiven a path (-tee-stdout=path). Output is dropped rather than slowing\ndown forwarding if the terminal or file can't keep up.",
+	)
+	flag.Var(
+		&teeStderr,
+		"tee-stderr",
+		"also echo the child's stderr to log2fluent's own stderr, or to a file if\ngiven a path (-tee-stderr=path). Output is dropped rather than slowing\ndown forwarding if the terminal or file can't keep up.",
 	)
 	flag.UintVar(
 		&bufLen,
@@ -63,6 +130,204 @@ func main() {
 		8192,
 		"message buffer length, i.e. the number of messages buffered before being\ndropped.",
 	)
+	flag.TextVar(
+		&backpressure,
+		"backpressure",
+		internal.BackpressureDrop,
+		"what to do when the message buffer is full: drop (the newest message),\nblock (stop reading until there is room), or drop-oldest.",
+	)
+	flag.UintVar(
+		&batchSize,
+		"batch-size",
+		1,
+		"maximum number of messages to send to Fluent in a single batch. Batching\nis enabled if this is greater than 1 or if -batch-bytes is set.",
+	)
+	flag.UintVar(
+		&batchBytes,
+		"batch-bytes",
+		0,
+		"maximum number of message bytes to send to Fluent in a single batch (0\nfor no limit).",
+	)
+	flag.DurationVar(
+		&flushInterval,
+		"flush-interval",
+		time.Second,
+		"maximum time to hold a batch before sending it, regardless of its size.",
+	)
+	flag.TextVar(
+		&batchMode,
+		"batch-mode",
+		internal.BatchModePacked,
+		"the Forward protocol mode used to send batches: forward or packed.",
+	)
+	flag.TextVar(
+		&compression,
+		"compress",
+		internal.CompressionNone,
+		"compression to apply to messages sent to Fluent: none or gzip. Most\neffective when combined with batching.",
+	)
+	flag.BoolVar(
+		&requireAck,
+		"require-ack",
+		false,
+		"require Fluent to acknowledge each message (or batch). Messages that\naren't acknowledged are retried once after reconnecting, and then spilled\nif -spill-dir is set. Combine with -spill-dir for at-least-once delivery.",
+	)
+	flag.DurationVar(
+		&ackTimeout,
+		"ack-timeout",
+		10*time.Second,
+		"maximum time to wait for Fluent to acknowledge a message when\n-require-ack is set.",
+	)
+	flag.DurationVar(
+		&connectTimeout,
+		"connect-timeout",
+		10*time.Second,
+		"maximum time to wait for a connection to Fluent to be established (0\nfor no timeout).",
+	)
+	flag.StringVar(
+		&tlsOpts.CAFile,
+		"tls-ca",
+		"",
+		"path to a PEM encoded CA certificate bundle used to verify the Fluent\nserver's certificate for tls:// destinations (defaults to the system's\nroot CAs).",
+	)
+	flag.StringVar(
+		&tlsOpts.CertFile,
+		"tls-cert",
+		"",
+		"path to a PEM encoded client certificate for mutual TLS.",
+	)
+	flag.StringVar(
+		&tlsOpts.KeyFile,
+		"tls-key",
+		"",
+		"path to the PEM encoded private key of the -tls-cert client certificate.",
+	)
+	flag.StringVar(
+		&tlsOpts.ServerName,
+		"tls-server-name",
+		"",
+		"the name used to verify the Fluent server's certificate (defaults to the\nhost of the destination address).",
+	)
+	flag.BoolVar(
+		&tlsOpts.InsecureSkipVerify,
+		"tls-insecure-skip-verify",
+		false,
+		"disable verification of the Fluent server's certificate. Insecure - use\nfor testing only.",
+	)
+	flag.StringVar(
+		&authOpts.SharedKey,
+		"shared-key",
+		"",
+		"shared key for authenticating with Fluent servers which require the\nForward protocol handshake (e.g. Fluentd's <security> shared_key).",
+	)
+	flag.StringVar(
+		&authOpts.SelfHostname,
+		"self-hostname",
+		"",
+		"the hostname sent to the Fluent server during the handshake (defaults to\nthis machine's hostname).",
+	)
+	flag.StringVar(
+		&authOpts.Username,
+		"username",
+		"",
+		"username for Fluent servers which require user authentication.",
+	)
+	flag.StringVar(
+		&passwordFile,
+		"password-file",
+		"",
+		"path to a file containing the password for -username.",
+	)
+	flag.StringVar(
+		&spillDir,
+		"spill-dir",
+		"",
+		"directory in which to persist messages that couldn't be sent to Fluent,\nto be replayed once the connection is re-established (disabled if empty).",
+	)
+	flag.Int64Var(
+		&spillMaxBytes,
+		"spill-max-bytes",
+		64<<20,
+		"maximum size in bytes of the spilled messages per stream (0 for\nunlimited).",
+	)
+	flag.DurationVar(
+		&drainTimeout,
+		"drain-timeout",
+		5*time.Second,
+		"maximum time to wait for buffered messages to be forwarded after the\nchild process exits.",
+	)
+	flag.DurationVar(
+		&killTimeout,
+		"kill-timeout",
+		10*time.Second,
+		"time to wait for the child process to exit after relaying a termination\nsignal before killing it (0 to wait indefinitely).",
+	)
+	flag.BoolVar(
+		&signalGroup,
+		"signal-group",
+		false,
+		"relay signals to the child's entire process group rather than just the\nchild process itself.",
+	)
+	flag.TextVar(
+		&sup.policy,
+		"restart",
+		RestartNever,
+		"when to restart the child process after it exits: never, on-failure (if\nit exits with a non-zero code or due to a signal), or always. Logs keep\nbeing forwarded across restarts.",
+	)
+	flag.DurationVar(
+		&sup.delay,
+		"restart-delay",
+		time.Second,
+		"how long to wait before restarting the child. The delay doubles after\neach restart, up to -restart-max-delay, and is reset once the child has\nrun for longer than -restart-window.",
+	)
+	flag.DurationVar(
+		&sup.maxDelay,
+		"restart-max-delay",
+		time.Minute,
+		"the maximum delay before restarting the child.",
+	)
+	flag.IntVar(
+		&sup.maxRestarts,
+		"max-restarts",
+		5,
+		"the maximum number of restarts within -restart-window, after which the\nchild is no longer restarted (0 for unlimited).",
+	)
+	flag.DurationVar(
+		&sup.window,
+		"restart-window",
+		time.Minute,
+		"the period over which -max-restarts applies.",
+	)
+	flag.BoolVar(
+		&lifecycleEvents,
+		"lifecycle-events",
+		false,
+		"send the child's lifecycle events (start, exit and restart, with its pid,\nexit code, signal, run time and resource usage) to Fluent, tagged\n<tag>.lifecycle. They are sent to the destinations of the first forwarded\nstream.",
+	)
+	flag.StringVar(
+		&metricsAddr,
+		"metrics-addr",
+		"",
+		"address on which to serve Prometheus metrics at /metrics, e.g. :9100\n(disabled if empty).",
+	)
+	flag.DurationVar(
+		&statsInterval,
+		"stats-interval",
+		0,
+		"how often to send a record per stream to Fluent, tagged <tag>.log2fluent,\nwith the number of lines read, sent and dropped, and of reconnections,\nsince the previous one (disabled if 0).",
+	)
+	flag.StringVar(
+		&healthAddr,
+		"health-addr",
+		"",
+		"address on which to serve health checks, e.g. :8080 (disabled if empty).\n/healthz succeeds while the child is running, and /readyz while every\nstream is connected to Fluent and has room in its buffer.",
+	)
+	flag.Float64Var(
+		&readyMaxBuffer,
+		"ready-max-buffer",
+		0.9,
+		"the fraction of a stream's message buffer which may be full before\n/readyz fails.",
+	)
 	flag.StringVar(
 		&extraAttrs,
 		"extra",
@@ -84,6 +349,29 @@ func main() {
 	flag.Usage = usage
 	flag.Parse()
 
+	// specsFor returns the specs of the streams to forward, given those of the
+	// config file.
+	specsFor := func(streams fdFlags) (fdFlags, error) {
+		return streamSpecs(outDest, errDest, extraFds, streams, &teeStdout, &teeStderr)
+	}
+	if flag.Arg(0) == "validate-config" {
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		os.Exit(validateConfig(flag.Arg(1), &defaults, specsFor))
+	}
+	if configPath != "" {
+		root, err := loadConfig(configPath)
+		if err != nil {
+			logFatal("error loading config", "error", err)
+		}
+		var errs []error
+		if streams, errs = applyConfig(configPath, root, flag.CommandLine, &defaults); len(errs) > 0 {
+			logFatal("invalid config", "error", errors.Join(errs...))
+		}
+	}
+
 	if printVersion {
 		fmt.Println(version)
 		os.Exit(0)
@@ -101,22 +389,101 @@ func main() {
 	h := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})
 	slog.SetDefault(slog.New(h))
 
-	extra := parseExtraAttrs(extraAttrs)
+	tlsConfig, err := internal.NewTLSConfig(tlsOpts)
+	if err != nil {
+		logFatal("error configuring TLS", "error", err)
+	}
+	var auth *internal.AuthOptions
+	if authOpts.SharedKey != "" || authOpts.Username != "" {
+		auth = &authOpts
+		if auth.SelfHostname == "" {
+			if auth.SelfHostname, err = os.Hostname(); err != nil {
+				logFatal("error getting hostname", "error", err)
+			}
+		}
+		if passwordFile != "" {
+			password, err := os.ReadFile(passwordFile)
+			if err != nil {
+				logFatal("error reading password file", "error", err)
+			}
+			auth.Password = strings.TrimRight(string(password), "\r\n")
+		}
+	}
+	cfg := &forwarderConfig{
+		defaults: &defaults,
+		tag:      tag,
+		extra:    parseExtraAttrs(extraAttrs),
+		opts: internal.ForwarderOptions{
+			BufLen:        bufLen,
+			Backpressure:  backpressure,
+			BatchSize:     batchSize,
+			BatchBytes:    batchBytes,
+			FlushInterval: flushInterval,
+		},
+		loggerOpts: internal.FluentLoggerOptions{
+			BatchMode:      batchMode,
+			Compression:    compression,
+			RequireAck:     requireAck,
+			AckTimeout:     ackTimeout,
+			ConnectTimeout: connectTimeout,
+			TLSConfig:      tlsConfig,
+			Auth:           auth,
+		},
+		spillDir:      spillDir,
+		spillMaxBytes: spillMaxBytes,
+	}
 
-	// Create pipes for child process's standard streams.
-	stdout := os.Stdout
-	if outDest != "" {
-		var fwd *internal.Forwarder
-		outPipe, fwd = newPipeAndForwarder("stdout", outDest, tag, bufLen, extra)
+	// Create pipes for child process's standard streams, and any additional
+	// file descriptors.
+	var pipes []*pipe
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	specs, err := specsFor(streams)
+	if err != nil {
+		logFatal("invalid config", "error", err)
+	}
+	for _, spec := range specs {
+		p, fwd := newPipeAndForwarder(spec, cfg)
+		pipes = append(pipes, p)
 		fwdrs = append(fwdrs, fwd)
-		stdout = outPipe.writeFd
+		for len(files) <= spec.fd {
+			// Descriptors in between are closed in the child.
+			files = append(files, nil)
+		}
+		files[spec.fd] = p.writeFd
 	}
-	stderr := os.Stderr
-	if errDest != "" {
-		var fwd *internal.Forwarder
-		errPipe, fwd = newPipeAndForwarder("stderr", errDest, tag, bufLen, extra)
+	var events *lifecycle
+	if lifecycleEvents {
+		if len(specs) == 0 {
+			logFatal("-lifecycle-events requires at least one stream to be forwarded")
+		}
+		p, fwd := newPipeAndForwarder(selfSpec(specs[0], cfg, lifecycleStream), cfg)
 		fwdrs = append(fwdrs, fwd)
-		stderr = errPipe.writeFd
+		events = &lifecycle{w: p.writeFd, command: flag.Args()}
+	}
+	var (
+		statsPipe *pipe
+		statsFwdr *internal.Forwarder
+	)
+	if statsInterval > 0 {
+		if len(specs) == 0 {
+			logFatal("-stats-interval requires at least one stream to be forwarded")
+		}
+		statsPipe, statsFwdr = newPipeAndForwarder(selfSpec(specs[0], cfg, statsStream), cfg)
+	}
+
+	var metrics net.Listener
+	if metricsAddr != "" {
+		// Listen before starting the child, so that a bad address is reported
+		// straight away.
+		if metrics, err = net.Listen("tcp", metricsAddr); err != nil {
+			logFatal("error listening for metrics", "error", err)
+		}
+	}
+	var health net.Listener
+	if healthAddr != "" {
+		if health, err = net.Listen("tcp", healthAddr); err != nil {
+			logFatal("error listening for health checks", "error", err)
+		}
 	}
 
 	// Start child process.
@@ -127,29 +494,99 @@ func main() {
 	attr := os.ProcAttr{
 		Dir:   cwd,
 		Env:   os.Environ(),
-		Files: []*os.File{os.Stdin, stdout, stderr},
+		Files: files,
+		Sys:   sysProcAttr(signalGroup),
 	}
 	child, err := os.StartProcess(flag.Arg(0), flag.Args(), &attr)
 	if err != nil {
 		logFatal("error executing %s: %v", flag.Arg(0), err)
 	}
-	// Close write file descriptors in the parent process.
-	if outPipe != nil {
-		_ = outPipe.writeFd.Close()
+	running.Store(true)
+	events.started(child, 0)
+	if sup.policy == RestartNever {
+		// Close write file descriptors in the parent process. Otherwise, they
+		// are kept open for the restarted children, so the forwarders don't
+		// see the end of the pipes until the last child exits.
+		closePipes(pipes)
 	}
-	if errPipe != nil {
-		_ = errPipe.writeFd.Close()
+	// Termination signals stop the child from being restarted. Being notified
+	// of them also keeps us running if they are received between restarts.
+	term := make(chan os.Signal, 1)
+	if sup.policy != RestartNever {
+		signal.Notify(term, terminationSignals...)
 	}
 
 	// Start forwarding logs to Fluent.
 	for _, fwdr := range fwdrs {
 		fwdr.Forward()
 	}
+	if metrics != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", internal.MetricsHandler(fwdrs))
+		serve(metrics, mux)
+	}
+	if health != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", healthHandler(&running))
+		mux.Handle("/readyz", readyHandler(fwdrs, readyMaxBuffer))
+		serve(health, mux)
+	}
+	var stats *telemetry
+	if statsFwdr != nil {
+		stats = startTelemetry(statsPipe.writeFd, fwdrs, statsInterval)
+		statsFwdr.Forward()
+	}
 
-	// Wait for child process to exit.
-	state, err := child.Wait()
-	if err != nil {
-		logFatal("error waiting for child process", "error", err)
+	// Wait for child process to exit, and restart it according to the restart
+	// policy.
+	started := time.Now()
+	state := waitChild(child, signalGroup, killTimeout)
+	running.Store(false)
+	events.exited(state, time.Since(started))
+restart:
+	for restarts := 1; ; restarts++ {
+		delay, ok := sup.restart(!state.Success(), time.Since(started), time.Now())
+		if !ok {
+			break
+		}
+		select {
+		case <-term:
+			break restart
+		default:
+		}
+		slog.Warn("child process exited; restarting it", "status", state.String(), "delay", delay)
+		events.restarting(delay, restarts)
+		select {
+		case <-term:
+			break restart
+		case <-time.After(delay):
+		}
+		started = time.Now()
+		if child, err = os.StartProcess(flag.Arg(0), flag.Args(), &attr); err != nil {
+			slog.Error("error restarting child process", "error", err)
+			events.startFailed(err, restarts)
+			continue
+		}
+		running.Store(true)
+		events.started(child, restarts)
+		state = waitChild(child, signalGroup, killTimeout)
+		running.Store(false)
+		events.exited(state, time.Since(started))
+	}
+	signal.Stop(term)
+	if sup.policy != RestartNever {
+		closePipes(pipes)
+	}
+	_ = events.Close()
+	// Give the forwarders a chance to flush any buffered messages before we
+	// exit.
+	drainStart := time.Now()
+	drain(fwdrs, drainTimeout)
+	if stats != nil {
+		// The final stats are reported once everything else has been
+		// forwarded, so they are complete.
+		_ = stats.Close()
+		drain([]*internal.Forwarder{statsFwdr}, drainTimeout-time.Since(drainStart))
 	}
 	if !state.Exited() {
 		// Child process terminated due to a signal.
@@ -160,11 +597,123 @@ func main() {
 	os.Exit(state.ExitCode())
 }
 
+// waitChild waits for the child process to exit, and returns its state. Until
+// it does, termination signals are relayed to it so that it can exit
+// gracefully, while we keep running (and forwarding logs).
+func waitChild(child *os.Process, group bool, killTimeout time.Duration) *os.ProcessState {
+	stopRelay := relaySignals(child, group, killTimeout)
+	defer stopRelay()
+	state, err := child.Wait()
+	if err != nil {
+		logFatal("error waiting for child process", "error", err)
+	}
+	return state
+}
+
+// serve serves the handler on the listener in the background, until we exit.
+func serve(l net.Listener, handler http.Handler) {
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(l); err != nil {
+			slog.Error("error serving HTTP", "addr", l.Addr(), "error", err)
+		}
+	}()
+}
+
+// closePipes closes the write ends of the pipes in this process.
+func closePipes(pipes []*pipe) {
+	for _, p := range pipes {
+		_ = p.writeFd.Close()
+	}
+}
+
+// validateConfig checks the config file at the given path, printing any errors
+// it has. It returns the exit code: 0 if the config is valid, 1 otherwise.
+func validateConfig(path string, defaults *streamSettings, specsFor func(fdFlags) (fdFlags, error)) int {
+	root, err := loadConfig(path)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	streams, errs := applyConfig(path, root, flag.CommandLine, defaults)
+	if len(errs) == 0 {
+		if _, err := defaults.options(internal.ForwarderOptions{}); err != nil {
+			errs = append(errs, &configError{path: path, err: err})
+		}
+		if _, err := specsFor(streams); err != nil {
+			errs = append(errs, &configError{path: path, err: err})
+		}
+	}
+	if len(errs) > 0 {
+		for _, err := range errs {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+		}
+		return 1
+	}
+	fmt.Println("config is valid")
+	return 0
+}
+
+// streamSpecs returns the specs of the child's streams to forward: those of the
+// -stdout, -stderr and -fd flags, merged with the given streams of the config
+// file. It returns an error if they are invalid, e.g. if a stream has no
+// destinations, or its settings or tee file are invalid.
+func streamSpecs(outDest, errDest string, extraFds, streams fdFlags, teeStdout, teeStderr *teeFlag) (fdFlags, error) {
+	var specs fdFlags
+	if outDest != "" {
+		specs = append(specs, fdSpec{fd: 1, dest: outDest, stream: "stdout"})
+	}
+	if errDest != "" {
+		specs = append(specs, fdSpec{fd: 2, dest: errDest, stream: "stderr"})
+	}
+	specs = append(specs, extraFds...)
+	if err := specs.validate(); err != nil {
+		return nil, fmt.Errorf("invalid -fd: %w", err)
+	}
+	// Streams configured in the config file fill in what the flags don't set.
+	specs = specs.merge(streams)
+	if err := specs.validate(); err != nil {
+		return nil, err
+	}
+	for i, spec := range specs {
+		switch {
+		case spec.dest == "":
+			return nil, fmt.Errorf("stream %q has no destinations", spec.stream)
+		case spec.fd == 1 && teeStdout.enabled:
+			specs[i].tee = teeStdout
+		case spec.fd == 2 && teeStderr.enabled:
+			specs[i].tee = teeStderr
+		}
+		if err := specs[i].tee.validate(); err != nil {
+			return nil, fmt.Errorf("stream %q: %w", spec.stream, err)
+		}
+		if spec.settings != nil {
+			if _, err := spec.settings.options(internal.ForwarderOptions{}); err != nil {
+				return nil, fmt.Errorf("stream %q: %w", spec.stream, err)
+			}
+		}
+	}
+	return specs, nil
+}
+
 func logFatal(format string, args ...any) {
 	slog.Error(format, args...)
 	os.Exit(1)
 }
 
+// drain waits for all the given forwarders to finish forwarding their buffered
+// messages, up to the given timeout. Any messages that have not been forwarded
+// by then are lost.
+func drain(fwdrs []*internal.Forwarder, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, fwdr := range fwdrs {
+		if err := fwdr.Wait(ctx); err != nil {
+			slog.Warn("timed out waiting for forwarder to drain", "name", fwdr.Name(), "error", err)
+		}
+	}
+}
+
 func parseExtraAttrs(s string) map[string]string {
 	entries := strings.Split(s, ",")
 	extra := make(map[string]string)
@@ -177,6 +726,25 @@ func parseExtraAttrs(s string) map[string]string {
 	return extra
 }
 
+func parseFieldTypes(s string) (map[string]internal.FieldType, error) {
+	types := make(map[string]internal.FieldType)
+	for _, e := range strings.Split(s, ",") {
+		if strings.TrimSpace(e) == "" {
+			continue
+		}
+		name, typ, ok := strings.Cut(e, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid field type %q: expected name:type", e)
+		}
+		t, err := internal.ParseFieldType(strings.TrimSpace(typ))
+		if err != nil {
+			return nil, err
+		}
+		types[strings.TrimSpace(name)] = t
+	}
+	return types, nil
+}
+
 func parseLocation(loc string) (string, string) {
 	var network, addr string
 	parts := strings.SplitN(loc, "://", 2)
@@ -202,19 +770,257 @@ func newPipe() (*pipe, error) {
 	return &pipe{readFd: readFd, writeFd: writeFd}, nil
 }
 
-func newPipeAndForwarder(stream, dest, tag string, bufLen uint, extra map[string]string) (
-	*pipe,
-	*internal.Forwarder,
-) {
+// forwarderConfig holds the settings shared by all forwarders.
+type forwarderConfig struct {
+	tag           string
+	extra         map[string]string
+	opts          internal.ForwarderOptions
+	defaults      *streamSettings // The settings of streams without their own.
+	loggerOpts    internal.FluentLoggerOptions
+	spillDir      string
+	spillMaxBytes int64
+}
+
+// newPipeAndForwarder creates a pipe, and a Forwarder which forwards the lines
+// written to it as described by spec. If the spec has several (comma
+// separated) destinations, they are used according to the stream's mode. The
+// forwarder's messages are tagged with the spec's streamTag.
+func newPipeAndForwarder(spec fdSpec, cfg *forwarderConfig) (*pipe, *internal.Forwarder) {
 	p, err := newPipe()
 	if err != nil {
 		logFatal("error creating pipe: %v", err)
 	}
-	network, addr := parseLocation(dest)
-	if tag == "" {
-		tag = stream
+	stream := spec.stream
+	tag := streamTag(spec, cfg)
+	settings := spec.settings
+	if settings == nil {
+		settings = cfg.defaults
+	}
+	opts, err := settings.options(cfg.opts)
+	if err != nil {
+		logFatal("invalid stream settings", "stream", stream, "error", err)
+	}
+	if cfg.spillDir != "" {
+		// Each stream gets its own spill.
+		opts.Spill, err = internal.NewSpill(filepath.Join(cfg.spillDir, stream), cfg.spillMaxBytes)
+		if err != nil {
+			logFatal("error creating spill", "stream", stream, "error", err)
+		}
+	}
+	def := os.Stdout
+	if spec.fd == 2 {
+		def = os.Stderr
+	}
+	if w := spec.tee.writer(def); w != nil {
+		opts.Tee = internal.NewTee(w)
+	}
+	var loggers []internal.Logger
+	for _, dest := range strings.Split(spec.dest, ",") {
+		network, addr := parseLocation(strings.TrimSpace(dest))
+		loggers = append(loggers, internal.NewFluentLogger(network, addr, tag, stream, cfg.extra, cfg.loggerOpts))
+	}
+	logger := loggers[0]
+	if len(loggers) > 1 {
+		logger = internal.NewMultiLogger(loggers, settings.mode)
 	}
-	logger := internal.NewFluentLogger(network, addr, tag, stream, extra)
-	fwd := internal.NewForwarder(stream, bufLen, p.readFd, logger)
+	fwd := internal.NewForwarder(stream, p.readFd, logger, opts)
 	return p, fwd
 }
+
+// streamTag returns the tag of the messages described by spec: the spec's tag,
+// or the configured tag if it's empty, or the stream name if that is empty too.
+func streamTag(spec fdSpec, cfg *forwarderConfig) string {
+	switch {
+	case spec.tag != "":
+		return spec.tag
+	case cfg.tag != "":
+		return cfg.tag
+	default:
+		return spec.stream
+	}
+}
+
+// The names of the streams of log2fluent's own records: the child's lifecycle
+// events, and the stats records.
+const (
+	lifecycleStream = "lifecycle"
+	statsStream     = "log2fluent"
+)
+
+// The names of the streams of log2fluent's own records. The child's streams
+// can't use them, since the streams' spills would share a directory.
+var reservedStreams = []string{lifecycleStream, statsStream}
+
+// selfSpec returns the spec of a stream of log2fluent's own JSON records (e.g.
+// the child's lifecycle events), with the given name. The records are sent to
+// the destinations of the given spec, with its tag suffixed by the name.
+func selfSpec(spec fdSpec, cfg *forwarderConfig, name string) fdSpec {
+	settings := newStreamSettings()
+	settings.format = internal.FormatJSON
+	settings.mode = cfg.defaults.mode
+	if spec.settings != nil {
+		settings.mode = spec.settings.mode
+	}
+	return fdSpec{
+		dest:     spec.dest,
+		tag:      streamTag(spec, cfg) + "." + name,
+		stream:   name,
+		settings: &settings,
+	}
+}
+
+// fdSpec describes a file descriptor of the child process to capture.
+type fdSpec struct {
+	fd     int      // The descriptor's number in the child.
+	dest   string   // Where to forward the descriptor's logs to, comma separated.
+	tag    string   // The tag for the descriptor's logs, if not the default.
+	stream string   // The stream name of the descriptor's logs.
+	tee    *teeFlag // Where to echo the descriptor's output, if anywhere.
+	// The stream's own settings, or nil if it uses the defaults.
+	settings *streamSettings
+}
+
+// fdFlags is a flag.Value holding the -fd flags, which have the form
+// N=dest[,dest...][,tag=...][,stream=...].
+type fdFlags []fdSpec
+
+func (f *fdFlags) String() string {
+	if f == nil {
+		return ""
+	}
+	specs := make([]string, len(*f))
+	for i, spec := range *f {
+		specs[i] = fmt.Sprintf("%d=%s,tag=%s,stream=%s", spec.fd, spec.dest, spec.tag, spec.stream)
+	}
+	return strings.Join(specs, " ")
+}
+
+func (f *fdFlags) Set(s string) error {
+	n, rest, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("invalid fd %q: expected N=dest", s)
+	}
+	fd, err := strconv.Atoi(strings.TrimSpace(n))
+	if err != nil || fd < 3 {
+		return fmt.Errorf("invalid fd %q: N must be a number greater than 2", s)
+	}
+	spec := fdSpec{fd: fd, stream: "fd" + strconv.Itoa(fd)}
+	var dests []string
+	for _, opt := range strings.Split(rest, ",") {
+		k, v, ok := strings.Cut(opt, "=")
+		if !ok {
+			// Options are key=value pairs, anything else is a destination.
+			if dest := strings.TrimSpace(opt); dest != "" {
+				dests = append(dests, dest)
+			}
+			continue
+		}
+		v = strings.TrimSpace(v)
+		switch strings.TrimSpace(k) {
+		case "tag":
+			spec.tag = v
+		case "stream":
+			if v == "" || strings.ContainsAny(v, `/\`) {
+				return fmt.Errorf("invalid fd %q: invalid stream name %q", s, v)
+			}
+			spec.stream = v
+		default:
+			return fmt.Errorf("invalid fd %q: unknown option %q", s, opt)
+		}
+	}
+	if len(dests) == 0 {
+		return fmt.Errorf("invalid fd %q: missing destination", s)
+	}
+	spec.dest = strings.Join(dests, ",")
+	*f = append(*f, spec)
+	return nil
+}
+
+// validate returns an error if any descriptor or stream name is used more than
+// once, or if a stream name is reserved.
+func (f fdFlags) validate() error {
+	fds := make(map[int]bool)
+	streams := make(map[string]bool)
+	for _, spec := range f {
+		if slices.Contains(reservedStreams, spec.stream) {
+			return fmt.Errorf("stream name %q is reserved", spec.stream)
+		}
+		if fds[spec.fd] {
+			return fmt.Errorf("fd %d is captured more than once", spec.fd)
+		}
+		if streams[spec.stream] {
+			return fmt.Errorf("stream %q is used more than once", spec.stream)
+		}
+		fds[spec.fd], streams[spec.stream] = true, true
+	}
+	return nil
+}
+
+// teeFlag is a flag.Value for the -tee-* flags, which may be given either
+// without a value (or with a boolean), to echo to log2fluent's own stream, or
+// with the path of a file to echo to.
+type teeFlag struct {
+	enabled bool
+	path    string
+}
+
+func (f *teeFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (f *teeFlag) String() string {
+	if f == nil || !f.enabled {
+		return ""
+	}
+	if f.path != "" {
+		return f.path
+	}
+	return "true"
+}
+
+func (f *teeFlag) Set(s string) error {
+	if enabled, err := strconv.ParseBool(s); err == nil {
+		f.enabled, f.path = enabled, ""
+		return nil
+	}
+	f.enabled, f.path = true, s
+	return nil
+}
+
+// validate returns an error if the flag's file can't be written to. The file
+// isn't created if it doesn't exist.
+func (f *teeFlag) validate() error {
+	if f == nil || !f.enabled || f.path == "" {
+		return nil
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		// It will be created, so its directory must exist.
+		var info os.FileInfo
+		if info, err = os.Stat(filepath.Dir(f.path)); err == nil && !info.IsDir() {
+			err = fmt.Errorf("%s is not a directory", filepath.Dir(f.path))
+		}
+	} else if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("invalid tee file %q: %w", f.path, err)
+	}
+	return nil
+}
+
+// writer returns where to echo to - def, or the flag's file - or nil if the
+// flag is nil or isn't enabled.
+func (f *teeFlag) writer(def *os.File) io.Writer {
+	if f == nil || !f.enabled {
+		return nil
+	}
+	if f.path == "" {
+		return def
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		logFatal("error opening tee file", "path", f.path, "error", err)
+	}
+	return file
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/ccampo133/log2fluent/internal"
 	"github.com/stretchr/testify/require"
 )
 
@@ -63,6 +66,52 @@ func Test_parseExtraAttrs(t *testing.T) {
 	}
 }
 
+func Test_parseFieldTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    map[string]internal.FieldType
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "empty string",
+			s:       "",
+			want:    map[string]internal.FieldType{},
+			wantErr: require.NoError,
+		},
+		{
+			name: "comma separated with random whitespace",
+			s:    " status: int,latency:float , ok:bool,,name:string",
+			want: map[string]internal.FieldType{
+				"status":  internal.FieldTypeInt,
+				"latency": internal.FieldTypeFloat,
+				"ok":      internal.FieldTypeBool,
+				"name":    internal.FieldTypeString,
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "missing type",
+			s:       "status",
+			wantErr: require.Error,
+		},
+		{
+			name:    "invalid type",
+			s:       "status:number",
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := parseFieldTypes(tt.s)
+				tt.wantErr(t, err)
+				require.Equal(t, tt.want, got)
+			},
+		)
+	}
+}
+
 func Test_parseLocation(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -82,6 +131,12 @@ func Test_parseLocation(t *testing.T) {
 			wantNetwork: "udp",
 			wantAddr:    "localhost:1234",
 		},
+		{
+			name:        "tls",
+			loc:         "tls://localhost:1234",
+			wantNetwork: "tls",
+			wantAddr:    "localhost:1234",
+		},
 		{
 			name:        "unix",
 			loc:         "unix:///path/to/sock",
@@ -99,3 +154,282 @@ func Test_parseLocation(t *testing.T) {
 		)
 	}
 }
+
+func Test_fdFlags_Set(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    fdSpec
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "destination only",
+			s:       "3=localhost:24224",
+			want:    fdSpec{fd: 3, dest: "localhost:24224", stream: "fd3"},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "tag and stream",
+			s:       "4=unix:///var/run/fluent.sock, tag=access ,stream=access",
+			want:    fdSpec{fd: 4, dest: "unix:///var/run/fluent.sock", tag: "access", stream: "access"},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "several destinations",
+			s:       "3=primary:24224, standby:24224,stream=audit",
+			want:    fdSpec{fd: 3, dest: "primary:24224,standby:24224", stream: "audit"},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "options only",
+			s:       "3=tag=audit",
+			wantErr: require.Error,
+		},
+		{
+			name:    "missing destination",
+			s:       "3=",
+			wantErr: require.Error,
+		},
+		{
+			name:    "not a number",
+			s:       "three=localhost:24224",
+			wantErr: require.Error,
+		},
+		{
+			name:    "standard stream",
+			s:       "1=localhost:24224",
+			wantErr: require.Error,
+		},
+		{
+			name:    "unknown option",
+			s:       "3=localhost:24224,foo=bar",
+			wantErr: require.Error,
+		},
+		{
+			name:    "invalid stream name",
+			s:       "3=localhost:24224,stream=../audit",
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var f fdFlags
+				err := f.Set(tt.s)
+				tt.wantErr(t, err)
+				if err == nil {
+					require.Equal(t, fdFlags{tt.want}, f)
+				}
+			},
+		)
+	}
+}
+
+func Test_fdFlags_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		f       fdFlags
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name: "unique",
+			f: fdFlags{
+				{fd: 1, stream: "stdout"},
+				{fd: 3, stream: "fd3"},
+				{fd: 4, stream: "audit"},
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "duplicate fd",
+			f:       fdFlags{{fd: 3, stream: "fd3"}, {fd: 3, stream: "audit"}},
+			wantErr: require.Error,
+		},
+		{
+			name:    "duplicate stream",
+			f:       fdFlags{{fd: 1, stream: "stdout"}, {fd: 3, stream: "stdout"}},
+			wantErr: require.Error,
+		},
+		{
+			name:    "reserved stream",
+			f:       fdFlags{{fd: 3, stream: lifecycleStream}},
+			wantErr: require.Error,
+		},
+		{
+			name:    "reserved stats stream",
+			f:       fdFlags{{fd: 3, stream: statsStream}},
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				tt.wantErr(t, tt.f.validate())
+			},
+		)
+	}
+}
+
+func Test_streamSpecs(t *testing.T) {
+	json := newStreamSettings()
+	json.format = internal.FormatJSON
+	badRegex := newStreamSettings()
+	badRegex.parserRegex = "("
+	tests := []struct {
+		name      string
+		outDest   string
+		extraFds  fdFlags
+		streams   fdFlags
+		teeStdout teeFlag
+		want      fdFlags
+		wantErr   string
+	}{
+		{
+			name:     "flags and config",
+			outDest:  "localhost:24224",
+			extraFds: fdFlags{{fd: 3, dest: "localhost:24225", stream: "fd3"}},
+			streams: fdFlags{
+				{fd: 1, stream: "stdout", settings: &json},
+				{fd: 4, dest: "localhost:24226", stream: "audit"},
+			},
+			want: fdFlags{
+				{fd: 1, dest: "localhost:24224", stream: "stdout", settings: &json},
+				{fd: 3, dest: "localhost:24225", stream: "fd3"},
+				{fd: 4, dest: "localhost:24226", stream: "audit"},
+			},
+		},
+		{
+			name:      "tee flag",
+			outDest:   "localhost:24224",
+			teeStdout: teeFlag{enabled: true},
+			want: fdFlags{
+				{fd: 1, dest: "localhost:24224", stream: "stdout", tee: &teeFlag{enabled: true}},
+			},
+		},
+		{
+			name:    "config stream without destinations",
+			streams: fdFlags{{fd: 3, stream: "audit"}},
+			wantErr: `stream "audit" has no destinations`,
+		},
+		{
+			name:    "config stdout without -stdout",
+			streams: fdFlags{{fd: 1, stream: "stdout", settings: &json}},
+			wantErr: `stream "stdout" has no destinations`,
+		},
+		{
+			name:     "duplicate fd across flags and config",
+			extraFds: fdFlags{{fd: 3, dest: "localhost:24225", stream: "fd3"}},
+			streams:  fdFlags{{fd: 3, dest: "localhost:24226", stream: "audit"}},
+			wantErr:  "fd 3 is captured more than once",
+		},
+		{
+			name:    "invalid stream settings",
+			streams: fdFlags{{fd: 3, dest: "localhost:24225", stream: "audit", settings: &badRegex}},
+			wantErr: `stream "audit"`,
+		},
+		{
+			name:      "invalid tee file",
+			outDest:   "localhost:24224",
+			teeStdout: teeFlag{enabled: true, path: filepath.Join(t.TempDir(), "missing", "app.log")},
+			wantErr:   "invalid tee file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var teeStderr teeFlag
+				specs, err := streamSpecs(tt.outDest, "", tt.extraFds, tt.streams, &tt.teeStdout, &teeStderr)
+				if tt.wantErr != "" {
+					require.ErrorContains(t, err, tt.wantErr)
+					return
+				}
+				require.NoError(t, err)
+				require.Equal(t, tt.want, specs)
+			},
+		)
+	}
+}
+
+func Test_teeFlag_validate(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.log")
+	require.NoError(t, os.WriteFile(existing, nil, 0o644))
+	tests := []struct {
+		name    string
+		f       *teeFlag
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "nil",
+			wantErr: require.NoError,
+		},
+		{
+			name:    "own stream",
+			f:       &teeFlag{enabled: true},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "existing file",
+			f:       &teeFlag{enabled: true, path: existing},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "new file",
+			f:       &teeFlag{enabled: true, path: filepath.Join(dir, "new.log")},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "missing directory",
+			f:       &teeFlag{enabled: true, path: filepath.Join(dir, "missing", "new.log")},
+			wantErr: require.Error,
+		},
+		{
+			name:    "directory",
+			f:       &teeFlag{enabled: true, path: dir},
+			wantErr: require.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				tt.wantErr(t, tt.f.validate())
+			},
+		)
+	}
+	// Validating doesn't create the file.
+	_, err := os.Stat(filepath.Join(dir, "new.log"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func Test_teeFlag_Set(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want teeFlag
+	}{
+		{
+			name: "no value",
+			s:    "true",
+			want: teeFlag{enabled: true},
+		},
+		{
+			name: "false",
+			s:    "false",
+			want: teeFlag{},
+		},
+		{
+			name: "file",
+			s:    "/var/log/app.log",
+			want: teeFlag{enabled: true, path: "/var/log/app.log"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var f teeFlag
+				require.NoError(t, f.Set(tt.s))
+				require.Equal(t, tt.want, f)
+			},
+		)
+	}
+}
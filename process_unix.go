@@ -0,0 +1,56 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// signalNames are the names of the signals most commonly terminating a
+// process.
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGALRM: "SIGALRM",
+	syscall.SIGBUS:  "SIGBUS",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGTERM: "SIGTERM",
+	syscall.SIGTRAP: "SIGTRAP",
+	syscall.SIGUSR1: "SIGUSR1",
+	syscall.SIGUSR2: "SIGUSR2",
+}
+
+// exitSignal returns the name of the signal which terminated the process, or
+// an empty string if it wasn't terminated by a signal.
+func exitSignal(state *os.ProcessState) string {
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return ""
+	}
+	if name, ok := signalNames[ws.Signal()]; ok {
+		return name
+	}
+	return ws.Signal().String()
+}
+
+// maxRSS returns the maximum resident set size of the process in bytes, if
+// known.
+func maxRSS(state *os.ProcessState) (int64, bool) {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || rusage == nil {
+		return 0, false
+	}
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		// Reported in bytes, rather than kilobytes as elsewhere.
+		return int64(rusage.Maxrss), true
+	}
+	return int64(rusage.Maxrss) * 1024, true
+}
@@ -0,0 +1,55 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_lifecycle_exited(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		exitCode float64
+		signal   any
+	}{
+		{
+			name:     "exit code",
+			script:   "exit 3",
+			exitCode: 3,
+		},
+		{
+			name:     "signal",
+			script:   "kill -TERM $$",
+			exitCode: -1,
+			signal:   "SIGTERM",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				child := startChild(t, false, "sh", "-c", tt.script)
+				state, err := child.Wait()
+				require.NoError(t, err)
+				var buf bytes.Buffer
+				l := &lifecycle{w: nopWriteCloser{&buf}, command: []string{"sh"}}
+				l.exited(state, 2*time.Second)
+				evts := events(t, &buf)
+				require.Len(t, evts, 1)
+				event := evts[0]
+				require.Equal(t, "exit", event["event"])
+				require.Equal(t, float64(child.Pid), event["pid"])
+				require.Equal(t, tt.exitCode, event["exit_code"])
+				require.Equal(t, tt.signal, event["signal"])
+				require.Equal(t, 2.0, event["duration_seconds"])
+				require.Contains(t, event, "user_cpu_seconds")
+				require.Contains(t, event, "system_cpu_seconds")
+				require.Greater(t, event["max_rss_bytes"], float64(0))
+			},
+		)
+	}
+}
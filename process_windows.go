@@ -0,0 +1,15 @@
+package main
+
+import "os"
+
+// exitSignal returns the name of the signal which terminated the process.
+// Processes aren't terminated by signals on Windows, so it is always empty.
+func exitSignal(*os.ProcessState) string {
+	return ""
+}
+
+// maxRSS returns the maximum resident set size of the process in bytes, which
+// isn't known on Windows.
+func maxRSS(*os.ProcessState) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,117 @@
+//go:build unix
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// relayedSignals are the signals that are relayed to the child process.
+var relayedSignals = []os.Signal{
+	syscall.SIGTERM,
+	syscall.SIGINT,
+	syscall.SIGHUP,
+	syscall.SIGQUIT,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+}
+
+// terminationSignals are the relayed signals which ask the child process to
+// terminate.
+var terminationSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT}
+
+// terminalSignals are the relayed signals which the terminal sends to its
+// whole foreground process group, e.g. on Ctrl-C.
+var terminalSignals = []os.Signal{syscall.SIGINT, syscall.SIGQUIT}
+
+// sysProcAttr returns the platform specific attributes used to start the
+// child process. If group is true, the child is started in its own process
+// group so that signals can be relayed to all of its descendants.
+func sysProcAttr(group bool) *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: group}
+}
+
+// relaySignals relays termination signals received by this process to the
+// child process (or its process group if group is true), so that the child
+// has a chance to shut down gracefully while its logs continue to be
+// forwarded. If the child is still running killTimeout after the first
+// termination signal is relayed, it is sent SIGKILL. A killTimeout of zero
+// disables this escalation. The returned function stops relaying signals and
+// should be called once the child process has exited.
+//
+// If the child isn't in its own process group, and we are in the foreground of
+// a terminal, SIGINT and SIGQUIT aren't relayed: they were most likely sent by
+// the terminal (e.g. on Ctrl-C), so the child received them too, and relaying
+// them would deliver them twice.
+func relaySignals(child *os.Process, group bool, killTimeout time.Duration) (stop func()) {
+	foreground := !group && inForeground()
+	sigs := make(chan os.Signal, len(relayedSignals))
+	signal.Notify(sigs, relayedSignals...)
+	done := make(chan struct{})
+	go func() {
+		defer signal.Stop(sigs)
+		var kill <-chan time.Time
+		for {
+			select {
+			case sig := <-sigs:
+				if shouldRelay(sig, foreground) {
+					slog.Debug("relaying signal to child process", "signal", sig)
+					if err := signalChild(child, sig, group); err != nil {
+						slog.Error("error relaying signal to child process", "signal", sig, "error", err)
+					}
+				} else {
+					slog.Debug("not relaying signal the child received from the terminal", "signal", sig)
+				}
+				if kill == nil && killTimeout > 0 && isTermination(sig) {
+					kill = time.After(killTimeout)
+				}
+			case <-kill:
+				slog.Warn("child process did not exit in time; killing it", "timeout", killTimeout)
+				if err := signalChild(child, syscall.SIGKILL, group); err != nil {
+					slog.Error("error killing child process", "error", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func signalChild(child *os.Process, sig os.Signal, group bool) error {
+	if group {
+		// A negative PID signals the entire process group.
+		return syscall.Kill(-child.Pid, sig.(syscall.Signal))
+	}
+	return child.Signal(sig)
+}
+
+// shouldRelay returns whether to relay the signal to the child, given whether
+// the child is in the foreground process group of a terminal along with us.
+func shouldRelay(sig os.Signal, foreground bool) bool {
+	return !foreground || !slices.Contains(terminalSignals, sig)
+}
+
+// inForeground returns true if this process is in the foreground process group
+// of its controlling terminal, i.e. it receives the signals the terminal sends.
+func inForeground() bool {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		// No controlling terminal.
+		return false
+	}
+	defer func() { _ = tty.Close() }()
+	pgrp, err := unix.IoctlGetInt(int(tty.Fd()), unix.TIOCGPGRP)
+	return err == nil && pgrp == syscall.Getpgrp()
+}
+
+func isTermination(sig os.Signal) bool {
+	return slices.Contains(terminationSignals, sig)
+}
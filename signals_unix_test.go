@@ -0,0 +1,85 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func startChild(t *testing.T, group bool, args ...string) *os.Process {
+	path, err := exec.LookPath(args[0])
+	require.NoError(t, err)
+	attr := os.ProcAttr{Sys: sysProcAttr(group)}
+	child, err := os.StartProcess(path, args, &attr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = child.Kill() })
+	return child
+}
+
+func Test_relaySignals_RelaysToChild(t *testing.T) {
+	child := startChild(t, false, "sleep", "30")
+	stop := relaySignals(child, false, 0)
+	defer stop()
+	// Signal ourselves - the signal should be relayed to the child rather than
+	// terminating the test process.
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	state, err := child.Wait()
+	require.NoError(t, err)
+	ws := state.Sys().(syscall.WaitStatus)
+	require.True(t, ws.Signaled())
+	require.Equal(t, syscall.SIGTERM, ws.Signal())
+}
+
+func Test_relaySignals_RelaysToProcessGroup(t *testing.T) {
+	child := startChild(t, true, "sleep", "30")
+	stop := relaySignals(child, true, 0)
+	defer stop()
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	state, err := child.Wait()
+	require.NoError(t, err)
+	ws := state.Sys().(syscall.WaitStatus)
+	require.True(t, ws.Signaled())
+	require.Equal(t, syscall.SIGUSR1, ws.Signal())
+}
+
+func Test_shouldRelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		sig        os.Signal
+		foreground bool
+		want       bool
+	}{
+		{name: "SIGINT in background", sig: syscall.SIGINT, want: true},
+		{name: "SIGINT in foreground", sig: syscall.SIGINT, foreground: true, want: false},
+		{name: "SIGQUIT in foreground", sig: syscall.SIGQUIT, foreground: true, want: false},
+		{name: "SIGTERM in foreground", sig: syscall.SIGTERM, foreground: true, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				require.Equal(t, tt.want, shouldRelay(tt.sig, tt.foreground))
+			},
+		)
+	}
+}
+
+func Test_relaySignals_KillsAfterTimeout(t *testing.T) {
+	// The child ignores SIGTERM, so it should be killed after the timeout.
+	child := startChild(t, false, "sh", "-c", `trap "" TERM; exec sleep 30`)
+	// Give the shell a moment to install its trap.
+	time.Sleep(100 * time.Millisecond)
+	stop := relaySignals(child, false, 100*time.Millisecond)
+	defer stop()
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	state, err := child.Wait()
+	require.NoError(t, err)
+	ws := state.Sys().(syscall.WaitStatus)
+	require.True(t, ws.Signaled())
+	require.Equal(t, syscall.SIGKILL, ws.Signal())
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// terminationSignals are the signals which ask the child process to terminate.
+var terminationSignals = []os.Signal{os.Interrupt}
+
+// sysProcAttr returns the platform specific attributes used to start the
+// child process. Process groups are not supported on Windows, so group is
+// ignored.
+func sysProcAttr(bool) *syscall.SysProcAttr {
+	return nil
+}
+
+// relaySignals keeps this process alive when it is interrupted, so that the
+// child's logs continue to be forwarded while it shuts down. Windows doesn't
+// support sending signals to other processes; the child receives console
+// interrupts directly. If the child is still running killTimeout after the
+// first interrupt, it is killed. A killTimeout of zero disables this. The
+// returned function stops handling interrupts and should be called once the
+// child process has exited.
+func relaySignals(child *os.Process, _ bool, killTimeout time.Duration) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		defer signal.Stop(sigs)
+		var kill <-chan time.Time
+		for {
+			select {
+			case <-sigs:
+				if kill == nil && killTimeout > 0 {
+					kill = time.After(killTimeout)
+				}
+			case <-kill:
+				slog.Warn("child process did not exit in time; killing it", "timeout", killTimeout)
+				if err := child.Kill(); err != nil {
+					slog.Error("error killing child process", "error", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/ccampo133/log2fluent/internal"
+)
+
+// streamSettings holds the settings which can be configured per stream. The
+// command line flags set the defaults for every stream, which a config file
+// may override for individual streams.
+type streamSettings struct {
+	format           internal.Format
+	parserRegex      string
+	parserTypes      string
+	keepRaw          bool
+	timeKey          string
+	timeFormat       string
+	multilineStart   string
+	multilinePreset  string
+	multilineTimeout time.Duration
+	maxLineBytes     uint
+	longLines        internal.LongLinePolicy
+	partialFlush     time.Duration
+	mode             internal.DestinationMode
+}
+
+// newStreamSettings returns the default stream settings.
+func newStreamSettings() streamSettings {
+	return streamSettings{
+		format:           internal.FormatPlain,
+		timeFormat:       internal.TimeFormatRFC3339,
+		multilineTimeout: time.Second,
+		longLines:        internal.LongLineTruncate,
+		mode:             internal.ModeFailover,
+	}
+}
+
+// register defines a flag in the flag set for each of the settings, with the
+// settings' current values as the defaults.
+func (s *streamSettings) register(fs *flag.FlagSet) {
+	fs.TextVar(
+		&s.mode,
+		"mode",
+		s.mode,
+		"how messages are sent when a stream has several addresses: failover (to\nthe first available address, in order of preference), broadcast (to all\nof them), or round-robin.",
+	)
+	fs.TextVar(
+		&s.format,
+		"format",
+		s.format,
+		"the format of the log lines: plain (sent as-is under the \"log\" key),\njson or logfmt (each line is parsed, and its fields sent as the record).\nLines which can't be parsed are sent as plain lines.",
+	)
+	fs.StringVar(
+		&s.parserRegex,
+		"parser-regex",
+		s.parserRegex,
+		"a regular expression with named capture groups used to parse each log\nline. The captured groups are sent as the record's fields. Lines which\ndon't match are sent as plain lines. Can't be combined with -format.",
+	)
+	fs.StringVar(
+		&s.parserTypes,
+		"parser-types",
+		s.parserTypes,
+		"comma separated list of -parser-regex group names and the types their\nvalues are converted to (string, int, float or bool), e.g.\nstatus:int,latency:float.",
+	)
+	fs.BoolVar(
+		&s.keepRaw,
+		"keep-raw",
+		s.keepRaw,
+		"keep the raw line of parsed lines in the record, under the \"log\" key.",
+	)
+	fs.UintVar(
+		&s.maxLineBytes,
+		"max-line-bytes",
+		s.maxLineBytes,
+		"maximum length of a log line in bytes, 0 for unlimited. Longer lines are\nhandled according to -long-line-policy.",
+	)
+	fs.TextVar(
+		&s.longLines,
+		"long-line-policy",
+		s.longLines,
+		"what to do with lines longer than -max-line-bytes: truncate (annotated\nwith truncated=true), split (into parts annotated with split_id,\nsplit_index and split_last), or drop.",
+	)
+	fs.DurationVar(
+		&s.partialFlush,
+		"partial-flush",
+		s.partialFlush,
+		"how long to wait for the rest of a line without a trailing newline (e.g.\na progress bar or prompt) before sending what has been written so far,\nannotated with partial=true. 0 waits until the line is complete.",
+	)
+	fs.StringVar(
+		&s.timeKey,
+		"time-key",
+		s.timeKey,
+		"the parsed field holding the log's time, which is used as the event time\nsent to Fluent. If not set, or if the field is missing or invalid, the\ntime the line was read is used.",
+	)
+	fs.StringVar(
+		&s.timeFormat,
+		"time-format",
+		s.timeFormat,
+		"the format of the -time-key field: rfc3339, epoch (seconds since the\nUnix epoch), or a strftime layout, e.g. '%Y-%m-%d %H:%M:%S'.",
+	)
+	fs.StringVar(
+		&s.multilineStart,
+		"multiline-start",
+		s.multilineStart,
+		"a regular expression matching the first line of a multiline record, e.g.\n'^\\d{4}-'. Lines which don't match are appended to the preceding\nrecord.",
+	)
+	fs.StringVar(
+		&s.multilinePreset,
+		"multiline-preset",
+		s.multilinePreset,
+		"a built-in multiline pattern: java (exception stack traces), python\n(tracebacks) or go (panics and goroutine dumps). Can't be combined with\n-multiline-start.",
+	)
+	fs.DurationVar(
+		&s.multilineTimeout,
+		"multiline-timeout",
+		s.multilineTimeout,
+		"how long to wait for more lines of a multiline record before sending it.",
+	)
+}
+
+// options returns the given Forwarder options, with the stream specific ones
+// filled in from the settings. An error is returned if the settings are
+// invalid.
+func (s *streamSettings) options(opts internal.ForwarderOptions) (internal.ForwarderOptions, error) {
+	parser := s.format.Parser()
+	if s.parserRegex != "" {
+		if s.format != internal.FormatPlain {
+			return opts, fmt.Errorf("-parser-regex can't be combined with -format %s", s.format)
+		}
+		types, err := parseFieldTypes(s.parserTypes)
+		if err != nil {
+			return opts, fmt.Errorf("error parsing -parser-types: %w", err)
+		}
+		if parser, err = internal.NewRegexParser(s.parserRegex, types); err != nil {
+			return opts, err
+		}
+	}
+
+	var (
+		timeParser *internal.TimeParser
+		err        error
+	)
+	if s.timeKey != "" {
+		if parser == nil {
+			return opts, errors.New("-time-key requires -format or -parser-regex")
+		}
+		if timeParser, err = internal.NewTimeParser(s.timeKey, s.timeFormat); err != nil {
+			return opts, err
+		}
+	}
+
+	var multiline *internal.Multiline
+	switch {
+	case s.multilineStart != "" && s.multilinePreset != "":
+		return opts, errors.New("-multiline-start can't be combined with -multiline-preset")
+	case s.multilineStart != "":
+		if multiline, err = internal.NewMultiline(s.multilineStart, s.multilineTimeout); err != nil {
+			return opts, err
+		}
+	case s.multilinePreset != "":
+		if multiline, err = internal.NewMultilinePreset(s.multilinePreset, s.multilineTimeout); err != nil {
+			return opts, err
+		}
+	}
+
+	opts.Parser = parser
+	opts.KeepRaw = s.keepRaw
+	opts.Multiline = multiline
+	opts.TimeParser = timeParser
+	opts.MaxLineBytes = s.maxLineBytes
+	opts.LongLines = s.longLines
+	opts.PartialFlush = s.partialFlush
+	return opts, nil
+}
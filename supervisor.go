@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// RestartPolicy is when the child process is restarted after it exits.
+type RestartPolicy string
+
+const (
+	// RestartNever never restarts the child: log2fluent exits once it does.
+	// This is the default.
+	RestartNever RestartPolicy = "never"
+	// RestartOnFailure restarts the child if it exits with a non-zero exit
+	// code, or is terminated by a signal.
+	RestartOnFailure RestartPolicy = "on-failure"
+	// RestartAlways restarts the child whenever it exits.
+	RestartAlways RestartPolicy = "always"
+)
+
+// MarshalText implements encoding.TextMarshaler.
+func (p RestartPolicy) MarshalText() ([]byte, error) {
+	return []byte(p), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It returns an error if the
+// text is not a valid RestartPolicy.
+func (p *RestartPolicy) UnmarshalText(text []byte) error {
+	switch policy := RestartPolicy(text); policy {
+	case RestartNever, RestartOnFailure, RestartAlways:
+		*p = policy
+		return nil
+	default:
+		return fmt.Errorf("invalid restart policy %q", text)
+	}
+}
+
+// supervisor decides whether, and after how long, to restart the child process
+// when it exits. The delay before each restart doubles, from delay up to
+// maxDelay, and is reset once the child has run for longer than the window. The
+// child is restarted at most maxRestarts times within the window: if it exits
+// again after that many restarts, the supervisor gives up.
+type supervisor struct {
+	policy      RestartPolicy
+	delay       time.Duration // The delay before the first restart.
+	maxDelay    time.Duration // The maximum delay before a restart.
+	maxRestarts int           // The maximum restarts within the window, 0 for unlimited.
+	window      time.Duration
+	restarts    []time.Time   // When the child was restarted, within the window.
+	backoff     time.Duration // The delay before the next restart.
+}
+
+// restart returns whether to restart the child, which has just exited after
+// running for the given time, and if so how long to wait before doing so.
+// failed is whether the child exited unsuccessfully.
+func (s *supervisor) restart(failed bool, ran time.Duration, now time.Time) (time.Duration, bool) {
+	switch {
+	case s.policy == RestartAlways:
+	case s.policy == RestartOnFailure && failed:
+	default:
+		return 0, false
+	}
+	// Forget the restarts which are no longer within the window.
+	i := 0
+	for i < len(s.restarts) && now.Sub(s.restarts[i]) >= s.window {
+		i++
+	}
+	s.restarts = s.restarts[i:]
+	if s.maxRestarts > 0 && len(s.restarts) >= s.maxRestarts {
+		slog.Error("child process restarted too often; giving up", "restarts", len(s.restarts), "window", s.window)
+		return 0, false
+	}
+	if s.backoff == 0 || ran > s.window {
+		// The child ran long enough to be considered healthy.
+		s.backoff = s.delay
+	}
+	delay := s.backoff
+	s.backoff = min(2*s.backoff, s.maxDelay)
+	s.restarts = append(s.restarts, now.Add(delay))
+	return delay, true
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestartPolicy_UnmarshalText(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    RestartPolicy
+		wantErr require.ErrorAssertionFunc
+	}{
+		{text: "never", want: RestartNever, wantErr: require.NoError},
+		{text: "on-failure", want: RestartOnFailure, wantErr: require.NoError},
+		{text: "always", want: RestartAlways, wantErr: require.NoError},
+		{text: "invalid", wantErr: require.Error},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.text, func(t *testing.T) {
+				var p RestartPolicy
+				tt.wantErr(t, p.UnmarshalText([]byte(tt.text)))
+				require.Equal(t, tt.want, p)
+			},
+		)
+	}
+}
+
+func Test_supervisor_restart_Policy(t *testing.T) {
+	tests := []struct {
+		policy RestartPolicy
+		failed bool
+		want   bool
+	}{
+		{policy: RestartNever, failed: false, want: false},
+		{policy: RestartNever, failed: true, want: false},
+		{policy: RestartOnFailure, failed: false, want: false},
+		{policy: RestartOnFailure, failed: true, want: true},
+		{policy: RestartAlways, failed: false, want: true},
+		{policy: RestartAlways, failed: true, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(
+			string(tt.policy), func(t *testing.T) {
+				s := &supervisor{policy: tt.policy, delay: time.Second, maxDelay: time.Minute, window: time.Minute}
+				_, ok := s.restart(tt.failed, time.Second, time.Now())
+				require.Equal(t, tt.want, ok)
+			},
+		)
+	}
+}
+
+func Test_supervisor_restart_Backoff(t *testing.T) {
+	s := &supervisor{policy: RestartAlways, delay: time.Second, maxDelay: 5 * time.Second, window: time.Minute}
+	now := time.Now()
+	var delays []time.Duration
+	for range 5 {
+		delay, ok := s.restart(true, time.Second, now)
+		require.True(t, ok)
+		delays = append(delays, delay)
+		now = now.Add(delay + time.Second)
+	}
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, delays)
+
+	// The backoff is reset once the child has run for longer than the window.
+	delay, ok := s.restart(true, 2*time.Minute, now)
+	require.True(t, ok)
+	require.Equal(t, time.Second, delay)
+}
+
+func Test_supervisor_restart_MaxRestarts(t *testing.T) {
+	s := &supervisor{policy: RestartAlways, maxRestarts: 3, window: time.Minute}
+	now := time.Now()
+	for range 3 {
+		_, ok := s.restart(true, time.Second, now)
+		require.True(t, ok)
+		now = now.Add(time.Second)
+	}
+	_, ok := s.restart(true, time.Second, now)
+	require.False(t, ok)
+
+	// Restarts are allowed again once the earlier ones are outside the window.
+	_, ok = s.restart(true, time.Second, now.Add(time.Minute))
+	require.True(t, ok)
+}
+
+func Test_supervisor_restart_MaxRestarts_Boundary(t *testing.T) {
+	for _, maxRestarts := range []int{1, 5} {
+		t.Run(
+			strconv.Itoa(maxRestarts), func(t *testing.T) {
+				s := &supervisor{policy: RestartAlways, maxRestarts: maxRestarts, window: time.Minute}
+				now := time.Now()
+				// Exactly maxRestarts restarts are made within the window.
+				for i := range maxRestarts {
+					_, ok := s.restart(true, 0, now)
+					require.True(t, ok, "restart %d", i+1)
+				}
+				_, ok := s.restart(true, 0, now)
+				require.False(t, ok)
+			},
+		)
+	}
+}
+
+func Test_supervisor_restart_Unlimited(t *testing.T) {
+	s := &supervisor{policy: RestartAlways, window: time.Minute}
+	now := time.Now()
+	for range 100 {
+		_, ok := s.restart(true, 0, now)
+		require.True(t, ok)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/ccampo133/log2fluent/internal"
+)
+
+// telemetry periodically reports what the Forwarders did since the last
+// report, by writing a JSON line per Forwarder to w, which is read by a
+// Forwarder like any other stream. A nil telemetry reports nothing.
+type telemetry struct {
+	w     io.WriteCloser
+	fwdrs []*internal.Forwarder
+	last  []internal.Stats // The Forwarders' Stats as of the last report.
+	since time.Time        // When the last report was made.
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// startTelemetry starts reporting the Forwarders' Stats to w at the given
+// interval.
+func startTelemetry(w io.WriteCloser, fwdrs []*internal.Forwarder, interval time.Duration) *telemetry {
+	t := &telemetry{
+		w:     w,
+		fwdrs: fwdrs,
+		last:  make([]internal.Stats, len(fwdrs)),
+		since: time.Now(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.report()
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// report writes a record per Forwarder with the counts since the last report.
+func (t *telemetry) report() {
+	now := time.Now()
+	for i, fwdr := range t.fwdrs {
+		stats, last := fwdr.Stats(), t.last[i]
+		dropped := make(map[string]uint64, len(stats.Dropped))
+		for reason, n := range stats.Dropped {
+			dropped[string(reason)] = n - last.Dropped[reason]
+		}
+		record := map[string]any{
+			"forwarder":        fwdr.Name(),
+			"interval_seconds": now.Sub(t.since).Seconds(),
+			"lines_read":       stats.LinesRead - last.LinesRead,
+			"bytes_read":       stats.BytesRead - last.BytesRead,
+			"lines_sent":       stats.LinesSent - last.LinesSent,
+			"lines_dropped":    stats.TotalDropped() - last.TotalDropped(),
+			"dropped":          dropped,
+			"reconnects":       stats.Reconnects - last.Reconnects,
+			"queue_length":     stats.QueueLen,
+		}
+		t.last[i] = stats
+		b, err := json.Marshal(record)
+		if err != nil {
+			slog.Error("error encoding stats", "name", fwdr.Name(), "error", err)
+			continue
+		}
+		if _, err := t.w.Write(append(b, '\n')); err != nil {
+			slog.Error("error writing stats", "name", fwdr.Name(), "error", err)
+		}
+	}
+	t.since = now
+}
+
+// Close stops reporting, after a final report of what happened since the last
+// one.
+func (t *telemetry) Close() error {
+	if t == nil {
+		return nil
+	}
+	close(t.stop)
+	<-t.done
+	t.report()
+	return t.w.Close()
+}
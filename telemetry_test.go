@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ccampo133/log2fluent/internal"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_telemetry_report(t *testing.T) {
+	logger := internal.NewMockLogger(t)
+	logger.On("Connect").Return(nil)
+	logger.On("IsConnected").Return(true)
+	logger.On("Log", mock.Anything).Return(nil)
+	logger.On("Disconnect").Return(nil)
+	fwdr := internal.NewForwarder(
+		"stdout",
+		io.NopCloser(strings.NewReader("line1\nline2\n")),
+		logger,
+		internal.ForwarderOptions{BufLen: 8},
+	)
+	fwdr.Forward()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, fwdr.Wait(ctx))
+
+	var buf bytes.Buffer
+	stats := startTelemetry(nopWriteCloser{&buf}, []*internal.Forwarder{fwdr}, time.Hour)
+	stats.report()
+	require.NoError(t, stats.Close())
+
+	records := events(t, &buf)
+	require.Len(t, records, 2)
+	first := records[0]
+	require.Equal(t, "stdout", first["forwarder"])
+	require.Equal(t, float64(2), first["lines_read"])
+	require.Equal(t, float64(12), first["bytes_read"])
+	require.Equal(t, float64(2), first["lines_sent"])
+	require.Equal(t, float64(0), first["lines_dropped"])
+	require.Equal(t, float64(0), first["reconnects"])
+	require.Equal(t, float64(0), first["dropped"].(map[string]any)["buffer_full"])
+	require.Contains(t, first, "interval_seconds")
+	// The final report only counts what happened since the first.
+	second := records[1]
+	require.Equal(t, float64(0), second["lines_read"])
+	require.Equal(t, float64(0), second["lines_sent"])
+}